@@ -0,0 +1,55 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Int reads an integer environment variable, returning fallback when it is unset or invalid
+func Int(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %d", value, key, fallback)
+		return fallback
+	}
+
+	return parsed
+}
+
+// Float reads a float environment variable, returning fallback when it is unset or invalid
+func Float(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %g", value, key, fallback)
+		return fallback
+	}
+
+	return parsed
+}
+
+// Bool reads a boolean environment variable, returning fallback when it is unset or invalid
+func Bool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %t", value, key, fallback)
+		return fallback
+	}
+
+	return parsed
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+)
+
+// sensitiveEnvPatterns marks variables whose values are redacted by /env-vars. Matching
+// is a case-insensitive substring check on the name, so DATABASE_URL matches "URL".
+var sensitiveEnvPatterns = []string{"URL", "SECRET", "PASSWORD", "PASS", "KEY", "TOKEN", "CREDENTIAL", "DSN"}
+
+// redactedValue replaces the value of sensitive variables
+const redactedValue = "[REDACTED]"
+
+// redactedEnvironment turns KEY=value pairs into a map, redacting sensitive values
+func redactedEnvironment(environ []string) map[string]string {
+	envVars := make(map[string]string, len(environ))
+	for _, env := range environ {
+		// Split on first '=' to handle values that contain '='
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name, value := parts[0], parts[1]
+		if isSensitiveEnv(name) {
+			value = redactedValue
+		}
+		envVars[name] = value
+	}
+	return envVars
+}
+
+// isSensitiveEnv reports whether a variable name matches sensitiveEnvPatterns
+func isSensitiveEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, pattern := range sensitiveEnvPatterns {
+		if strings.Contains(upper, pattern) {
+			return true
+		}
+	}
+	return false
+}
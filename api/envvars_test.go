@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestRedactedEnvironment(t *testing.T) {
+	env := redactedEnvironment([]string{
+		"DATABASE_URL=postgres://mytracks:hunter2@db:5432/mytracks?sslmode=disable",
+		"API_KEY=abc123",
+		"github_token=ghp_xyz",
+		"PORT=8080",
+		"GIN_MODE=release",
+		"MALFORMED",
+	})
+
+	tests := []struct{ name, want string }{
+		{"DATABASE_URL", redactedValue},
+		{"API_KEY", redactedValue},
+		{"github_token", redactedValue},
+		{"PORT", "8080"},
+		{"GIN_MODE", "release"},
+	}
+	for _, tt := range tests {
+		if got := env[tt.name]; got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := env["MALFORMED"]; ok {
+		t.Error("entry without '=' should be skipped")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipResponseWriter buffers the response until it reaches minSize bytes and only then
+// switches to gzip, so small responses are sent uncompressed.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	buf     bytes.Buffer
+	minSize int
+	head    bool // HEAD request, whose headers are adjusted as if its body were sent
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minSize && w.Header().Get("Content-Encoding") == "" {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// startGzip sets the encoding headers and flushes the buffered bytes through gzip
+func (w *gzipResponseWriter) startGzip() error {
+	header := w.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish completes the response: closes the gzip stream, or sends the small buffered
+// body as-is when it never reached the threshold
+func (w *gzipResponseWriter) finish() {
+	if w.gz != nil {
+		w.gz.Close()
+		return
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	// A HEAD handler stops after setting Content-Length; a GET of that size would have
+	// been compressed, without a known length
+	if w.head && w.buf.Len() == 0 && header.Get("Content-Encoding") == "" {
+		if size, err := strconv.Atoi(header.Get("Content-Length")); err == nil && size >= w.minSize {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+			return
+		}
+	}
+
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip: listed as gzip or
+// x-gzip, or matched by *, with a q-value above zero. An explicit coding outranks *.
+func acceptsGzip(acceptEncoding string) bool {
+	quality, explicit := 0.0, false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding != "gzip" && coding != "x-gzip" && (coding != "*" || explicit) {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality, explicit = q, coding != "*"
+	}
+	return quality > 0
+}
+
+// gzipMiddleware compresses responses of at least minSize bytes for clients that accept gzip
+func gzipMiddleware(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// HEAD goes through here too, so its headers match GET's; net/http drops the body
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer, minSize: minSize, head: c.Request.Method == http.MethodHead}
+		c.Writer = writer
+		defer writer.finish()
+
+		c.Next()
+	}
+}
+
+// maxDecompressedBodySize caps how large a gzipped request body may inflate to
+const maxDecompressedBodySize = 64 << 20
+
+// gunzipRequestMiddleware decompresses request bodies sent with Content-Encoding: gzip, so
+// handlers can bind them as usual
+func gunzipRequestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		gzReader, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid gzip request body"})
+			return
+		}
+		defer gzReader.Close()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, gzReader, maxDecompressedBodySize)
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.ContentLength = -1
+
+		c.Next()
+	}
+}
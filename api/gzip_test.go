@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzipHeadMatchesGet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gzipMiddleware(1024))
+	handler := func(c *gin.Context) {
+		c.Header("Content-Type", "application/gpx+xml")
+		c.Status(http.StatusOK)
+		c.Writer.WriteString(strings.Repeat("<trkpt/>", 1000))
+	}
+	r.GET("/", handler)
+	r.HEAD("/", handler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	headers := make(map[string]http.Header)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, _ := http.NewRequest(method, server.URL, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if method == http.MethodHead && len(body) != 0 {
+			t.Fatalf("HEAD returned a %d byte body", len(body))
+		}
+		headers[method] = resp.Header
+	}
+
+	if got := headers[http.MethodGet].Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("GET Content-Encoding = %q, want gzip", got)
+	}
+	for _, header := range []string{"Content-Encoding", "Content-Length", "Vary"} {
+		if headers[http.MethodHead].Get(header) != headers[http.MethodGet].Get(header) {
+			t.Errorf("%s is %q on HEAD but %q on GET", header, headers[http.MethodHead].Get(header), headers[http.MethodGet].Get(header))
+		}
+	}
+}
+
+func TestGzipHeadWithoutBodyMatchesGet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gzipMiddleware(1024))
+	// Like DownloadTrack: the length is known up front and HEAD stops at the headers
+	handler := func(c *gin.Context) {
+		body := strings.Repeat("<trkpt/>", c.GetInt("points"))
+		c.Header("Content-Type", "application/gpx+xml")
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+		c.Status(http.StatusOK)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		c.Writer.WriteString(body)
+	}
+	for _, points := range []int{10, 1000} {
+		r.GET("/"+strconv.Itoa(points), func(c *gin.Context) { c.Set("points", points); handler(c) })
+		r.HEAD("/"+strconv.Itoa(points), func(c *gin.Context) { c.Set("points", points); handler(c) })
+	}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	for _, path := range []string{"/10", "/1000"} {
+		for _, encoding := range []string{"gzip", "identity"} {
+			headers := make(map[string]http.Header)
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				req, _ := http.NewRequest(method, server.URL+path, nil)
+				req.Header.Set("Accept-Encoding", encoding)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				headers[method] = resp.Header
+			}
+			compared := []string{"Content-Encoding", "Content-Length", "Vary"}
+			if headers[http.MethodGet].Get("Content-Encoding") == "gzip" {
+				// net/http only measures a compressed body that fits its buffer; HEAD can't
+				// know that length, so it leaves it out
+				compared = []string{"Content-Encoding", "Vary"}
+				if length := headers[http.MethodHead].Get("Content-Length"); length != "" {
+					t.Errorf("%s %s: HEAD Content-Length = %q, want none for a compressed body", path, encoding, length)
+				}
+			}
+			for _, header := range compared {
+				if headers[http.MethodHead].Get(header) != headers[http.MethodGet].Get(header) {
+					t.Errorf("%s %s: %s is %q on HEAD but %q on GET", path, encoding, header,
+						headers[http.MethodHead].Get(header), headers[http.MethodGet].Get(header))
+				}
+			}
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"gzip, deflate, br", true},
+		{"GZIP;q=0.5", true},
+		{"x-gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"deflate, br", false},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
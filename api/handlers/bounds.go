@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"mytracks-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// boundsError describes bounds query parameters that are missing, malformed or out of range
+type boundsError struct {
+	message string
+}
+
+func (e *boundsError) Error() string {
+	return e.message
+}
+
+// boundsParams are the query parameters of a bounding box, with the range of each
+var boundsParams = []struct {
+	name  string
+	limit float64
+}{
+	{"north", 90},
+	{"south", 90},
+	{"east", 180},
+	{"west", 180},
+}
+
+// parseBounds reads the north, south, east and west query parameters. It returns nil and
+// no error when none are given, so callers decide whether bounds are required. North and
+// south are swapped when given the wrong way round; east and west are left as they are,
+// since a west edge east of the east edge is a box crossing the antimeridian.
+func parseBounds(c *gin.Context) (*models.Bounds, error) {
+	var values [4]float64
+	given := 0
+	for i, param := range boundsParams {
+		raw := c.Query(param.name)
+		if raw == "" {
+			continue
+		}
+		given++
+
+		val, err := parseCoordinate(raw)
+		if err != nil {
+			return nil, &boundsError{fmt.Sprintf("Invalid %s coordinate", param.name)}
+		}
+		if val < -param.limit || val > param.limit {
+			return nil, &boundsError{fmt.Sprintf("%s must be between %g and %g", param.name, -param.limit, param.limit)}
+		}
+		values[i] = val
+	}
+
+	switch given {
+	case 0:
+		return nil, nil
+	case len(boundsParams):
+	default:
+		return nil, &boundsError{"Missing bounds parameters (north, south, east, west)"}
+	}
+
+	bounds := &models.Bounds{North: values[0], South: values[1], East: values[2], West: values[3]}
+	if bounds.South > bounds.North {
+		bounds.North, bounds.South = bounds.South, bounds.North
+	}
+	return bounds, nil
+}
+
+// parseCoordinate parses a finite number. ParseFloat accepts "NaN" and "Inf", and NaN
+// slips through range checks since every comparison with it is false.
+func parseCoordinate(raw string) (float64, error) {
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", raw)
+	}
+	return val, nil
+}
+
+// parseRequiredBounds is parseBounds for endpoints that can't run without a bounding box
+func parseRequiredBounds(c *gin.Context) (*models.Bounds, error) {
+	bounds, err := parseBounds(c)
+	if err == nil && bounds == nil {
+		err = &boundsError{"Missing bounds parameters (north, south, east, west)"}
+	}
+	return bounds, err
+}
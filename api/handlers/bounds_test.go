@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mytracks-api/models"
+	"mytracks-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func boundsContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/tracks/bounds?"+query, nil)
+	return c
+}
+
+func TestParseBounds(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  *models.Bounds
+	}{
+		{"none", "", nil},
+		{"valid", "north=48&south=47&east=-122&west=-123", &models.Bounds{North: 48, South: 47, East: -122, West: -123}},
+		{"swapped north and south", "north=47&south=48&east=-122&west=-123", &models.Bounds{North: 48, South: 47, East: -122, West: -123}},
+		{"across the antimeridian", "north=-15&south=-20&east=-178&west=177", &models.Bounds{North: -15, South: -20, East: -178, West: 177}},
+		{"edges of the range", "north=90&south=-90&east=180&west=-180", &models.Bounds{North: 90, South: -90, East: 180, West: -180}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBounds(boundsContext(tt.query))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTracksByBoundsRejectsInvalidBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"missing", "", "Missing bounds parameters (north, south, east, west)"},
+		{"partial", "north=48&south=47&east=-122", "Missing bounds parameters (north, south, east, west)"},
+		{"malformed north", "north=abc&south=47&east=-122&west=-123", "Invalid north coordinate"},
+		{"malformed west", "north=48&south=47&east=-122&west=", "Missing bounds parameters (north, south, east, west)"},
+		{"NaN north", "north=NaN&south=47&east=-122&west=-123", "Invalid north coordinate"},
+		{"NaN east", "north=48&south=47&east=nan&west=-123", "Invalid east coordinate"},
+		{"infinite south", "north=48&south=-Inf&east=-122&west=-123", "Invalid south coordinate"},
+		{"infinite west", "north=48&south=47&east=-122&west=+Inf", "Invalid west coordinate"},
+		{"north too large", "north=500&south=47&east=-122&west=-123", "north must be between -90 and 90"},
+		{"south too small", "north=48&south=-91&east=-122&west=-123", "south must be between -90 and 90"},
+		{"east too large", "north=48&south=47&east=181&west=-123", "east must be between -180 and 180"},
+		{"west too small", "north=48&south=47&east=-122&west=-180.5", "west must be between -180 and 180"},
+	}
+
+	// Every case fails before the service is reached, so none is needed
+	h := NewTrackHandler(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(http.MethodGet, "/tracks/bounds", "/tracks/bounds?"+tt.query, "", h.GetTracksByBounds)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.message {
+				t.Fatalf("error = %q, want %q", body.Error, tt.message)
+			}
+		})
+	}
+}
+
+func TestGetTracksRejectsPartialBounds(t *testing.T) {
+	h := NewTrackHandler(nil)
+	w := serve(http.MethodGet, "/tracks", "/tracks?north=48&south=NaN&east=-122&west=-123", "", h.GetTracks)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// pointGPX is a short track around lat/lon
+func pointGPX(lat, lon float64) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="%f" lon="%f"></trkpt>
+    <trkpt lat="%f" lon="%f"></trkpt>
+  </trkseg></trk>
+</gpx>`, lat, lon, lat+0.01, lon+0.01)
+}
+
+func TestGetTracksByBoundsAcrossAntimeridian(t *testing.T) {
+	trackService := services.NewTrackService(testDB(t), "")
+	fiji := createTestTrackFrom(t, trackService, "fiji.gpx", pointGPX(-17.7, 178.0))
+	alaska := createTestTrackFrom(t, trackService, "alaska.gpx", pointGPX(52.0, -176.5))
+	createTestTrackFrom(t, trackService, "atlantic.gpx", pointGPX(30.0, -40.0))
+
+	// A Pacific viewport from 170E to 170W
+	h := NewTrackHandler(trackService)
+	w := serve(http.MethodGet, "/tracks/bounds", "/tracks/bounds?north=60&south=-25&east=-170&west=170", "", h.GetTracksByBounds)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var tracks []models.GPXTrack
+	if err := json.Unmarshal(w.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	found := map[uint]bool{}
+	for _, track := range tracks {
+		found[track.ID] = true
+	}
+	if len(tracks) != 2 || !found[fiji.ID] || !found[alaska.ID] {
+		t.Fatalf("got tracks %v, want Fiji (%d) and Alaska (%d) only", found, fiji.ID, alaska.ID)
+	}
+}
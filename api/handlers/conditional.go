@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notModified sets ETag and Last-Modified for an export of a track, derived from its
+// UpdatedAt, and answers 304 when the client's If-None-Match or If-Modified-Since shows
+// its copy is current. It returns true when a response has already been written (304 or
+// a lookup error), in which case the caller must not generate the export.
+func (h *TrackHandler) notModified(c *gin.Context, id uint, format string) bool {
+	updatedAt, err := h.trackService.GetTrackUpdatedAt(c.Request.Context(), id)
+	if err != nil {
+		respondTrackError(c, err)
+		return true
+	}
+
+	// Weak, since the gzip middleware may change the bytes on the wire
+	etag := fmt.Sprintf(`W/"%d-%s-%d"`, id, format, updatedAt.UnixNano())
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if etagMatches(match, etag) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil && !updatedAt.Truncate(time.Second).After(t) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing weakly
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"mytracks-api/services"
+)
+
+func TestPostTrackCoordinatesSkipsDeletedTracks(t *testing.T) {
+	trackService := services.NewTrackService(testDB(t), "")
+	kept := createTestTrack(t, trackService, "kept.gpx")
+	deleted := createTestTrack(t, trackService, "deleted.gpx")
+	if err := trackService.DeleteTrack(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	h := NewTrackHandler(trackService)
+	body := fmt.Sprintf(`{"ids": [%d, %d]}`, kept.ID, deleted.ID)
+	w := serve(http.MethodPost, "/track_coordinates", "/track_coordinates", body, h.PostTrackCoordinates)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var coordinates map[uint][]services.TrackCoordinate
+	if err := json.Unmarshal(w.Body.Bytes(), &coordinates); err != nil {
+		t.Fatal(err)
+	}
+	if len(coordinates[kept.ID]) == 0 {
+		t.Errorf("no coordinates for track %d", kept.ID)
+	}
+	if points, ok := coordinates[deleted.ID]; ok {
+		t.Errorf("deleted track %d returned %d coordinates", deleted.ID, len(points))
+	}
+}
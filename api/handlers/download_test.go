@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"mytracks-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadServer serves GET and HEAD /tracks/:id/download over a real connection, so
+// net/http applies its HEAD handling
+func downloadServer(t *testing.T) (*httptest.Server, uint) {
+	t.Helper()
+	trackService := services.NewTrackService(testDB(t), "")
+	track := createTestTrack(t, trackService, "download.gpx")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewTrackHandler(trackService)
+	r.GET("/tracks/:id/download", h.DownloadTrack)
+	r.HEAD("/tracks/:id/download", h.DownloadTrack)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, track.ID
+}
+
+func fetch(t *testing.T, method, url, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setting Accept-Encoding stops the transport from asking for and undoing gzip itself
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestDownloadTrackGetSendsContentLength(t *testing.T) {
+	server, id := downloadServer(t)
+	url := fmt.Sprintf("%s/tracks/%d/download", server.URL, id)
+
+	resp, body := fetch(t, http.MethodGet, url, "identity")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Fatalf("Content-Length = %q, body is %d bytes", got, len(body))
+	}
+}
+
+func TestDownloadTrackHeadMatchesGet(t *testing.T) {
+	server, id := downloadServer(t)
+	url := fmt.Sprintf("%s/tracks/%d/download", server.URL, id)
+
+	for _, encoding := range []string{"identity", "gzip"} {
+		get, _ := fetch(t, http.MethodGet, url, encoding)
+		head, body := fetch(t, http.MethodHead, url, encoding)
+
+		if head.StatusCode != http.StatusOK {
+			t.Fatalf("%s: HEAD status = %d", encoding, head.StatusCode)
+		}
+		if len(body) != 0 {
+			t.Fatalf("%s: HEAD returned a %d byte body", encoding, len(body))
+		}
+		for _, header := range []string{"Content-Type", "Content-Disposition", "Content-Length", "Content-Encoding", "ETag"} {
+			if head.Header.Get(header) != get.Header.Get(header) {
+				t.Errorf("%s: %s is %q on HEAD but %q on GET", encoding, header, head.Header.Get(header), get.Header.Get(header))
+			}
+		}
+	}
+}
+
+func TestDownloadTrackMissing(t *testing.T) {
+	server, _ := downloadServer(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		resp, _ := fetch(t, method, server.URL+"/tracks/999999/download", "identity")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s status = %d, want %d", method, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mytracks-api/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestRespondTrackError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"missing track", gorm.ErrRecordNotFound, http.StatusNotFound},
+		{"wrapped missing track", fmt.Errorf("track 7: %w", gorm.ErrRecordNotFound), http.StatusNotFound},
+		{"database error", errors.New("connection refused"), http.StatusInternalServerError},
+		{"timeout", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/tracks/7", nil)
+
+			respondTrackError(c, tt.err)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackEndpointsMissingVsPresent(t *testing.T) {
+	trackService := services.NewTrackService(testDB(t), "")
+	track := createTestTrack(t, trackService, "present.gpx")
+	h := NewTrackHandler(trackService)
+
+	endpoints := []struct {
+		path    string
+		handler gin.HandlerFunc
+	}{
+		{"/tracks/:id", h.GetTrack},
+		{"/tracks/:id/summary", h.GetTrackSummary},
+		{"/tracks/:id/bounds", h.GetTrackBounds},
+		{"/tracks/:id/points", h.GetTrackPoints},
+		{"/tracks/:id/download", h.DownloadTrack},
+		{"/tracks/:id/geojson", h.GetTrackGeoJSON},
+		{"/tracks/:id/kml", h.DownloadTrackKML},
+		{"/tracks/:id/tcx", h.DownloadTrackTCX},
+		{"/tracks/:id/csv", h.DownloadTrackCSV},
+	}
+
+	for _, endpoint := range endpoints {
+		cases := []struct {
+			id   string
+			want int
+		}{
+			{fmt.Sprint(track.ID), http.StatusOK},
+			{"999999", http.StatusNotFound},
+			{"abc", http.StatusBadRequest},
+		}
+		for _, tc := range cases {
+			target := fmt.Sprintf("/tracks/%s%s", tc.id, endpoint.path[len("/tracks/:id"):])
+			w := serve(http.MethodGet, endpoint.path, target, "", endpoint.handler)
+			if w.Code != tc.want {
+				t.Errorf("GET %s: status = %d, want %d", target, w.Code, tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"mytracks-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trackPointFieldColumns maps the names accepted by ?fields= onto track_points columns
+var trackPointFieldColumns = map[string]string{
+	"id":         "id",
+	"track_id":   "track_id",
+	"lat":        "latitude",
+	"latitude":   "latitude",
+	"lon":        "longitude",
+	"longitude":  "longitude",
+	"ele":        "elevation",
+	"elevation":  "elevation",
+	"time":       "time",
+	"created_at": "created_at",
+}
+
+// defaultTrackPointColumns are returned when ?fields= is absent; IDs and import times are
+// of no use to the map
+var defaultTrackPointColumns = []string{"latitude", "longitude", "elevation", "time"}
+
+// parseTrackPointFields returns the track_points columns requested via ?fields=
+func parseTrackPointFields(c *gin.Context) ([]string, error) {
+	param := c.Query("fields")
+	if param == "" {
+		return defaultTrackPointColumns, nil
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(param, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		column, ok := trackPointFieldColumns[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return defaultTrackPointColumns, nil
+	}
+	return columns, nil
+}
+
+// trackPointView is a track point trimmed to the requested fields
+type trackPointView struct {
+	ID        *uint      `json:"id,omitempty"`
+	TrackID   *uint      `json:"track_id,omitempty"`
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	Elevation *float64   `json:"elevation,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// trackDetail is a track whose points carry only the requested fields
+type trackDetail struct {
+	models.GPXTrack
+	TrackPoints []trackPointView `json:"track_points"`
+}
+
+// projectTrackPoints returns the track with each point reduced to columns
+func projectTrackPoints(track models.GPXTrack, columns []string) trackDetail {
+	views := make([]trackPointView, len(track.TrackPoints))
+	for i := range track.TrackPoints {
+		point := &track.TrackPoints[i]
+		view := &views[i]
+		for _, column := range columns {
+			switch column {
+			case "id":
+				view.ID = &point.ID
+			case "track_id":
+				view.TrackID = &point.TrackID
+			case "latitude":
+				view.Latitude = &point.Latitude
+			case "longitude":
+				view.Longitude = &point.Longitude
+			case "elevation":
+				view.Elevation = point.Elevation
+			case "time":
+				view.Time = point.Time
+			case "created_at":
+				view.CreatedAt = &point.CreatedAt
+			}
+		}
+	}
+	return trackDetail{GPXTrack: track, TrackPoints: views}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 1000 // What GET /tracks returned before limits were configurable; the map sends no limit
+	maxListLimit     = 1000
+	limitHeader      = "X-Limit"
+)
+
+// parseLimit reads ?limit= for a list endpoint. A missing limit gets the handler's default
+// and one above the maximum is clamped to it; zero, negative or non-numeric values are
+// rejected with a 400. The limit used is reported in the X-Limit header. It returns false
+// when a response has already been written.
+func (h *TrackHandler) parseLimit(c *gin.Context) (int, bool) {
+	limit := h.defaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a positive integer (max %d)", h.maxLimit)})
+			return 0, false
+		}
+		limit = val
+	}
+	if limit > h.maxLimit {
+		limit = h.maxLimit
+	}
+
+	c.Header(limitHeader, strconv.Itoa(limit))
+	return limit, true
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetTracksNearbyRejectsInvalidLocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"missing lon", "lat=47.6"},
+		{"NaN lat", "lat=NaN&lon=-122.3"},
+		{"NaN lon", "lat=47.6&lon=NaN"},
+		{"infinite lat", "lat=Inf&lon=-122.3"},
+		{"lat out of range", "lat=91&lon=-122.3"},
+		{"lon out of range", "lat=47.6&lon=-181"},
+		{"NaN radius", "lat=47.6&lon=-122.3&radius_km=NaN"},
+		{"zero radius", "lat=47.6&lon=-122.3&radius_km=0"},
+	}
+
+	// Every case fails before the service is reached, so none is needed
+	h := NewTrackHandler(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(http.MethodGet, "/tracks/nearby", "/tracks/nearby?"+tt.query, "", h.GetTracksNearby)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
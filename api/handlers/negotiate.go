@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Media types of track responses. For GET /tracks/:id, trackMediaTypes is also the order of
+// preference for wildcards.
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeGPX     = "application/gpx+xml"
+	mediaTypeGeoJSON = "application/geo+json"
+	mediaTypeKML     = "application/vnd.google-earth.kml+xml"
+	mediaTypeTCX     = "application/vnd.garmin.tcx+xml"
+	mediaTypeCSV     = "text/csv"
+	mediaTypeNDJSON  = "application/x-ndjson"
+)
+
+var trackMediaTypes = []string{mediaTypeJSON, mediaTypeGPX, mediaTypeGeoJSON, mediaTypeKML, mediaTypeTCX, mediaTypeCSV}
+
+// trackListMediaTypes are the media types GET /tracks can respond with
+var trackListMediaTypes = []string{mediaTypeJSON, mediaTypeNDJSON}
+
+// negotiateMediaType picks the offered media type the Accept header rates highest. Ties go
+// to the earlier offer, so a missing header or */* gets offered[0]. It returns "" when the
+// header accepts none of them.
+func negotiateMediaType(accept string, offered []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offered[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offered {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives mediaType, using its most
+// specific matching range; 0 means not acceptable
+func acceptQuality(accept, mediaType string) float64 {
+	offerType, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var rangeSpecificity int
+		switch {
+		case mediaRange == mediaType:
+			rangeSpecificity = 2
+		case mediaRange == offerType+"/*":
+			rangeSpecificity = 1
+		case mediaRange == "*/*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality, specificity = q, rangeSpecificity
+	}
+	return quality
+}
+
+// GetTrack returns a track in the format its Accept header asks for: the JSON object by
+// default, or one of the export formats served by the download endpoints
+func (h *TrackHandler) GetTrack(c *gin.Context) {
+	// Add rather than set, so the gzip middleware's Vary: Accept-Encoding is kept
+	c.Writer.Header().Add("Vary", "Accept")
+
+	switch negotiateMediaType(c.GetHeader("Accept"), trackMediaTypes) {
+	case mediaTypeJSON:
+		h.getTrackJSON(c)
+	case mediaTypeGPX:
+		h.DownloadTrack(c)
+	case mediaTypeGeoJSON:
+		h.GetTrackGeoJSON(c)
+	case mediaTypeKML:
+		h.DownloadTrackKML(c)
+	case mediaTypeTCX:
+		h.DownloadTrackTCX(c)
+	case mediaTypeCSV:
+		h.DownloadTrackCSV(c)
+	default:
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "Supported types: " + strings.Join(trackMediaTypes, ", ")})
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"mytracks-api/services"
+)
+
+func TestSplitTrackTwice(t *testing.T) {
+	trackService := services.NewTrackService(testDB(t), "")
+	track := createTestTrack(t, trackService, "split.gpx")
+	h := NewTrackHandler(trackService)
+
+	// testGPX has a point a minute, so a 30 second gap splits it at every point
+	target := fmt.Sprintf("/tracks/%d/split?gap=0.5", track.ID)
+	seen := map[uint]bool{}
+	for attempt := 1; attempt <= 2; attempt++ {
+		w := serve(http.MethodPost, "/tracks/:id/split", target, "", h.SplitTrack)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("split %d: status = %d: %s", attempt, w.Code, w.Body)
+		}
+
+		var response struct {
+			TrackIDs []uint `json:"track_ids"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.TrackIDs) < 2 {
+			t.Fatalf("split %d: got %d parts, want several", attempt, len(response.TrackIDs))
+		}
+		for _, id := range response.TrackIDs {
+			if seen[id] {
+				t.Fatalf("split %d: part %d was returned before", attempt, id)
+			}
+			seen[id] = true
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"mytracks-api/models"
+	"mytracks-api/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testGPX is a small timed track with elevations, enough for every export
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><name>Test loop</name><trkseg>
+    <trkpt lat="47.6000" lon="-122.3300"><ele>10</ele><time>2024-05-01T08:00:00Z</time></trkpt>
+    <trkpt lat="47.6010" lon="-122.3290"><ele>14</ele><time>2024-05-01T08:01:00Z</time></trkpt>
+    <trkpt lat="47.6020" lon="-122.3280"><ele>19</ele><time>2024-05-01T08:02:00Z</time></trkpt>
+    <trkpt lat="47.6030" lon="-122.3270"><ele>15</ele><time>2024-05-01T08:03:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+// testDB connects to the Postgres database named by TEST_DATABASE_URL, migrates it and
+// empties the track tables. Tests that need a database are skipped when it isn't set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(url), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := models.AutoMigrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Exec("TRUNCATE gpx_tracks, track_points, waypoints RESTART IDENTITY").Error; err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// createTestTrack stores testGPX under filename and returns it
+func createTestTrack(t *testing.T, trackService *services.TrackService, filename string) *models.GPXTrack {
+	t.Helper()
+	return createTestTrackFrom(t, trackService, filename, testGPX)
+}
+
+// createTestTrackFrom stores the GPX document under filename and returns it
+func createTestTrackFrom(t *testing.T, trackService *services.TrackService, filename, gpxData string) *models.GPXTrack {
+	t.Helper()
+	track, err := services.NewGPXService().ParseGPXReader(strings.NewReader(gpxData), filename)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if err := trackService.CreateTrackWithPoints(track); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return track
+}
+
+// serve runs one request through a router with the handler registered at pattern
+func serve(method, pattern, target, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, pattern, handler)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
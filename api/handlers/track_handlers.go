@@ -1,115 +1,322 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"mytracks-api/config"
+	"mytracks-api/models"
 	"mytracks-api/services"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxTrackIDs caps how many tracks can be requested at once via the ids parameter
+const maxTrackIDs = 50
+
+// maxBulkTrackIDs caps how many tracks POST /track_coordinates accepts in its body
+const maxBulkTrackIDs = 500
+
+// Page sizes of GET /tracks/:id/points
+const (
+	defaultPointPageSize = 1000
+	maxPointPageSize     = 10000
 )
 
 type TrackHandler struct {
 	trackService *services.TrackService
+	defaultLimit int // Tracks returned by list endpoints when no limit is given
+	maxLimit     int // Largest limit list endpoints accept; higher values are clamped
 }
 
+// NewTrackHandler reads the list limits from DEFAULT_LIMIT and MAX_LIMIT
 func NewTrackHandler(trackService *services.TrackService) *TrackHandler {
-	return &TrackHandler{
+	h := &TrackHandler{
 		trackService: trackService,
+		defaultLimit: config.Int("DEFAULT_LIMIT", defaultListLimit),
+		maxLimit:     config.Int("MAX_LIMIT", maxListLimit),
 	}
+	if h.maxLimit < 1 {
+		h.maxLimit = maxListLimit
+	}
+	if h.defaultLimit < 1 || h.defaultLimit > h.maxLimit {
+		h.defaultLimit = h.maxLimit
+	}
+	return h
 }
 
 func (h *TrackHandler) GetTracks(c *gin.Context) {
 	// Parse query parameters
-	query := c.Query("q")
-
-	var minDistance, maxDistance *float64
-	var minDuration, maxDuration *int
-	var estimatedDuration *int
-	var north, south, east, west *float64
+	filters := services.TrackFilters{Query: c.Query("q"), Region: c.Query("region")}
 
 	// Parse distance filters
 	if minDistStr := c.Query("min_distance"); minDistStr != "" {
 		if val, err := strconv.ParseFloat(minDistStr, 64); err == nil {
-			minDistance = &val
+			filters.MinDistance = &val
 		}
 	}
 
 	if maxDistStr := c.Query("max_distance"); maxDistStr != "" {
 		if val, err := strconv.ParseFloat(maxDistStr, 64); err == nil {
-			maxDistance = &val
+			filters.MaxDistance = &val
 		}
 	}
 
 	// Parse duration filters
 	if minDurStr := c.Query("min_duration"); minDurStr != "" {
 		if val, err := strconv.Atoi(minDurStr); err == nil {
-			minDuration = &val
+			filters.MinDuration = &val
 		}
 	}
 
 	if maxDurStr := c.Query("max_duration"); maxDurStr != "" {
 		if val, err := strconv.Atoi(maxDurStr); err == nil {
-			maxDuration = &val
+			filters.MaxDuration = &val
+		}
+	}
+
+	// Parse moving time filters
+	if minMovingStr := c.Query("min_moving_time"); minMovingStr != "" {
+		if val, err := strconv.Atoi(minMovingStr); err == nil {
+			filters.MinMovingTime = &val
+		}
+	}
+
+	if maxMovingStr := c.Query("max_moving_time"); maxMovingStr != "" {
+		if val, err := strconv.Atoi(maxMovingStr); err == nil {
+			filters.MaxMovingTime = &val
 		}
 	}
 
 	// Parse estimated duration filter
 	if estDurStr := c.Query("estimated_duration"); estDurStr != "" {
 		if val, err := strconv.Atoi(estDurStr); err == nil {
-			estimatedDuration = &val
+			filters.EstimatedDuration = &val
 		}
 	}
 
-	// Parse geographic bounds (optional)
-	if northStr := c.Query("north"); northStr != "" {
-		if val, err := strconv.ParseFloat(northStr, 64); err == nil {
-			north = &val
+	// Parse point count filters
+	if minPointsStr := c.Query("min_points"); minPointsStr != "" {
+		if val, err := strconv.Atoi(minPointsStr); err == nil {
+			filters.MinPoints = &val
 		}
 	}
 
-	if southStr := c.Query("south"); southStr != "" {
-		if val, err := strconv.ParseFloat(southStr, 64); err == nil {
-			south = &val
+	if maxPointsStr := c.Query("max_points"); maxPointsStr != "" {
+		if val, err := strconv.Atoi(maxPointsStr); err == nil {
+			filters.MaxPoints = &val
 		}
 	}
 
-	if eastStr := c.Query("east"); eastStr != "" {
-		if val, err := strconv.ParseFloat(eastStr, 64); err == nil {
-			east = &val
+	// Parse bounding box area filters (km²)
+	if minAreaStr := c.Query("min_area"); minAreaStr != "" {
+		if val, err := strconv.ParseFloat(minAreaStr, 64); err == nil {
+			filters.MinAreaKm2 = &val
 		}
 	}
 
-	if westStr := c.Query("west"); westStr != "" {
-		if val, err := strconv.ParseFloat(westStr, 64); err == nil {
-			west = &val
+	if maxAreaStr := c.Query("max_area"); maxAreaStr != "" {
+		if val, err := strconv.ParseFloat(maxAreaStr, 64); err == nil {
+			filters.MaxAreaKm2 = &val
 		}
 	}
 
-	// Parse limit (default to 1000)
-	limit := 1000
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 {
-			limit = val
+	// Parse data availability filters
+	if hasElevationStr := c.Query("has_elevation"); hasElevationStr != "" {
+		if val, err := strconv.ParseBool(hasElevationStr); err == nil {
+			filters.HasElevation = &val
+		}
+	}
+
+	if hasTimeStr := c.Query("has_time"); hasTimeStr != "" {
+		if val, err := strconv.ParseBool(hasTimeStr); err == nil {
+			filters.HasTime = &val
+		}
+	}
+
+	// Parse date range filters; a plain end date includes that whole day
+	if startStr := c.Query("start_date"); startStr != "" {
+		val, _, err := parseDate(startStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date (expected RFC3339 or YYYY-MM-DD)"})
+			return
+		}
+		filters.StartDate = &val
+	}
+
+	if endStr := c.Query("end_date"); endStr != "" {
+		val, dateOnly, err := parseDate(endStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date (expected RFC3339 or YYYY-MM-DD)"})
+			return
 		}
+		if dateOnly {
+			val = val.AddDate(0, 0, 1)
+		} else {
+			val = val.Add(time.Nanosecond)
+		}
+		filters.EndDate = &val
+	}
+
+	// Parse sort order; the key itself is validated by the service
+	filters.Sort = c.DefaultQuery("sort", "created_at")
+	switch order := strings.ToLower(c.Query("order")); order {
+	case "":
+		// Newest (or most relevant) first by default, A to Z when sorting by name
+		filters.Descending = filters.Sort != "name"
+	case "asc", "desc":
+		filters.Descending = order == "desc"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
 	}
 
-	// Parse include_routes flag
+	// Parse geographic bounds (optional)
+	bounds, err := parseBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if bounds != nil {
+		filters.North, filters.South = &bounds.North, &bounds.South
+		filters.East, filters.West = &bounds.East, &bounds.West
+	}
+
+	limit, ok := h.parseLimit(c)
+	if !ok {
+		return
+	}
+
+	// Parse include_routes flag, optionally thinning each route for map previews
 	includeRoutes := c.Query("include_routes") == "true"
+	maxPointsPerTrack := 0
+	if maxPointsStr := c.Query("max_points_per_track"); maxPointsStr != "" {
+		val, err := strconv.Atoi(maxPointsStr)
+		if err != nil || val < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_points_per_track must be an integer of at least 2"})
+			return
+		}
+		maxPointsPerTrack = val
+	}
+
+	// Soft-deleted tracks are hidden unless explicitly requested
+	filters.IncludeDeleted = c.Query("include_deleted") == "true"
+
+	// A cursor parameter, even an empty one for the first page, switches to keyset pagination
+	cursorToken, paginated := c.GetQuery("cursor")
+	if cursorToken != "" {
+		cursor, err := services.DecodeTrackCursor(cursorToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		filters.Cursor = cursor
+	}
+
+	// NDJSON streams the tracks one per line instead of building one array
+	if c.Query("format") == "ndjson" || negotiateMediaType(c.GetHeader("Accept"), trackListMediaTypes) == mediaTypeNDJSON {
+		if includeRoutes || paginated {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ndjson can't be combined with include_routes or cursor"})
+			return
+		}
+		h.streamTracksNDJSON(c, filters, limit)
+		return
+	}
 
 	// Use the enhanced method that supports geographic filtering
-	tracks, err := h.trackService.GetTracksWithLocation(query, north, south, east, west, minDistance, maxDistance, minDuration, maxDuration, estimatedDuration, limit, includeRoutes)
+	tracks, err := h.trackService.GetTracksWithLocation(c.Request.Context(), filters, limit, includeRoutes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondTrackListError(c, err)
+		return
+	}
+	if maxPointsPerTrack > 0 {
+		for i := range tracks {
+			tracks[i].TrackPoints = services.DecimateTrackPoints(tracks[i].TrackPoints, maxPointsPerTrack)
+		}
+	}
+
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	if !paginated {
+		c.JSON(http.StatusOK, convertTracksUnits(tracks, units))
 		return
 	}
 
-	c.JSON(http.StatusOK, tracks)
+	// A short page is the last one
+	var nextCursor *string
+	if len(tracks) == limit {
+		token := services.CursorAfter(tracks[len(tracks)-1]).Encode()
+		nextCursor = &token
+	}
+	c.JSON(http.StatusOK, gin.H{"tracks": convertTracksUnits(tracks, units), "next_cursor": nextCursor})
+}
+
+// ndjsonFlushInterval is how many lines of an NDJSON response are written between flushes
+const ndjsonFlushInterval = 100
+
+// streamTracksNDJSON writes the track listing as newline-delimited JSON while the rows are
+// read. Errors before the first line get a normal JSON error response; later ones can
+// only end the stream early.
+func (h *TrackHandler) streamTracksNDJSON(c *gin.Context, filters services.TrackFilters, limit int) {
+	units := parseUnits(c)
+	encoder := json.NewEncoder(c.Writer)
+	written := 0
+
+	err := h.trackService.StreamTracksWithLocation(c.Request.Context(), filters, limit, func(track *models.GPXTrack) error {
+		if written == 0 {
+			c.Header(unitsHeader, units)
+			c.Header("Content-Type", mediaTypeNDJSON)
+			c.Status(http.StatusOK)
+		}
+		if err := encoder.Encode(convertTrackUnits(*track, units)); err != nil {
+			return err
+		}
+		written++
+		if written%ndjsonFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if written == 0 {
+			respondTrackListError(c, err)
+		} else {
+			log.Printf("Error streaming tracks after %d lines: %v", written, err)
+		}
+		return
+	}
+
+	if written == 0 {
+		c.Header(unitsHeader, units)
+		c.Data(http.StatusOK, mediaTypeNDJSON, nil)
+	}
+}
+
+// respondTrackListError reports a failed track listing, with 400s for bad sort or cursor
+// parameters
+func respondTrackListError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidSort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of created_at, distance, duration, name, start_time, relevance"})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor pagination requires sort=created_at"})
+		return
+	}
+	respondQueryError(c, err)
 }
 
-func (h *TrackHandler) GetTrack(c *gin.Context) {
+// getTrackJSON returns a track with its points as JSON
+func (h *TrackHandler) getTrackJSON(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
@@ -117,114 +324,259 @@ func (h *TrackHandler) GetTrack(c *gin.Context) {
 		return
 	}
 
-	track, err := h.trackService.GetTrackByID(uint(id))
+	// Only the requested point columns are read and returned
+	columns, err := parseTrackPointFields(c)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Track not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid fields: %v", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, track)
+	track, err := h.trackService.GetTrackByID(c.Request.Context(), uint(id), columns)
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	c.JSON(http.StatusOK, projectTrackPoints(convertTrackUnits(*track, units), columns))
 }
 
-func (h *TrackHandler) GetTracksByBounds(c *gin.Context) {
-	// Parse bounds parameters
-	northStr := c.Query("north")
-	southStr := c.Query("south")
-	eastStr := c.Query("east")
-	westStr := c.Query("west")
+// GetTrackPoints returns one page of a track's points, so clients can load long tracks
+// progressively
+func (h *TrackHandler) GetTrackPoints(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		val, err := strconv.Atoi(offsetStr)
+		if err != nil || val < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		offset = val
+	}
+
+	limit := defaultPointPageSize
+	if limitStr := c.Query("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a positive integer (max %d)", maxPointPageSize)})
+			return
+		}
+		limit = min(val, maxPointPageSize)
+	}
 
-	if northStr == "" || southStr == "" || eastStr == "" || westStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing bounds parameters (north, south, east, west)"})
+	points, total, err := h.trackService.GetTrackPointsPaged(c.Request.Context(), uint(id), offset, limit)
+	if err != nil {
+		respondTrackError(c, err)
 		return
 	}
 
-	north, err := strconv.ParseFloat(northStr, 64)
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	c.JSON(http.StatusOK, gin.H{
+		"points": convertTrackUnits(models.GPXTrack{TrackPoints: points}, units).TrackPoints,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
+
+// GetTrackDuplicates lists stored tracks that are likely the same route as the given track
+func (h *TrackHandler) GetTrackDuplicates(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid north coordinate"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
 		return
 	}
 
-	south, err := strconv.ParseFloat(southStr, 64)
+	duplicates, err := h.trackService.GetDuplicates(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid south coordinate"})
+		respondTrackError(c, err)
 		return
 	}
+	if duplicates == nil {
+		duplicates = []models.GPXTrack{}
+	}
+
+	c.JSON(http.StatusOK, duplicates)
+}
 
-	east, err := strconv.ParseFloat(eastStr, 64)
+// GetTrackNeighbors suggests tracks similar to the given one: overlapping area and a
+// comparable distance
+func (h *TrackHandler) GetTrackNeighbors(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid east coordinate"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	limit, ok := h.parseLimit(c)
+	if !ok {
 		return
 	}
 
-	west, err := strconv.ParseFloat(westStr, 64)
+	similar, err := h.trackService.FindSimilarTracks(c.Request.Context(), uint(id), limit)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid west coordinate"})
+		respondTrackError(c, err)
 		return
 	}
 
-	// Optional limit parameter (default 100, max 100)
-	limit := 100
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
+	c.JSON(http.StatusOK, similar)
+}
+
+// DeleteTrack soft-deletes a track; it can be brought back with RestoreTrack
+func (h *TrackHandler) DeleteTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if err := h.trackService.DeleteTrack(c.Request.Context(), uint(id)); err != nil {
+		respondTrackError(c, err)
+		return
 	}
 
-	tracks, err := h.trackService.GetTracksByBounds(north, south, east, west, limit)
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreTrack undoes a soft delete and returns the restored track
+func (h *TrackHandler) RestoreTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
 		return
 	}
 
-	c.JSON(http.StatusOK, tracks)
+	track, err := h.trackService.RestoreTrack(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, track)
 }
 
-func (h *TrackHandler) GetTrackCoordinates(c *gin.Context) {
-	// Parse the comma-separated list of track IDs
-	idsParam := c.Query("ids")
-	if idsParam == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'ids' parameter"})
+// SplitTrack splits a track into new tracks wherever its timestamps jump by more than
+// the gap (in minutes, default 30)
+func (h *TrackHandler) SplitTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
 		return
 	}
 
-	idStrings := strings.Split(idsParam, ",")
-	var trackIDs []uint
+	gapMinutes := 30.0
+	if gapStr := c.Query("gap"); gapStr != "" {
+		val, err := strconv.ParseFloat(gapStr, 64)
+		if err != nil || val <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gap parameter (minutes)"})
+			return
+		}
+		gapMinutes = val
+	}
 
-	for _, idStr := range idStrings {
-		idStr = strings.TrimSpace(idStr)
-		if idStr == "" {
-			continue
+	deleteOriginal := c.Query("delete_original") == "true"
+
+	gap := time.Duration(gapMinutes * float64(time.Minute))
+	ids, err := h.trackService.SplitByTimeGap(c.Request.Context(), uint(id), gap, deleteOriginal)
+	if err != nil {
+		if errors.Is(err, services.ErrNoTimeGaps) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Track has no gaps longer than %g minutes", gapMinutes)})
+			return
 		}
+		respondTrackError(c, err)
+		return
+	}
 
-		id, err := strconv.ParseUint(idStr, 10, 32)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid track ID: %s", idStr)})
+	c.JSON(http.StatusCreated, gin.H{"track_ids": ids, "original_deleted": deleteOriginal})
+}
+
+// ReverseTrack flips a track recorded in the wrong direction. By default the reversed
+// track is stored as a new track; in_place=true replaces the original's points instead.
+func (h *TrackHandler) ReverseTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	inPlace := c.Query("in_place") == "true"
+
+	track, err := h.trackService.ReverseTrack(c.Request.Context(), uint(id), inPlace)
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if inPlace {
+		status = http.StatusOK
+	}
+	c.JSON(status, track)
+}
+
+// GetTrackSplits returns per-interval splits (default every 1000 m)
+func (h *TrackHandler) GetTrackSplits(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	intervalMeters := 1000.0
+	if intervalStr := c.Query("interval_m"); intervalStr != "" {
+		val, err := strconv.ParseFloat(intervalStr, 64)
+		if err != nil || val < 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval_m must be a number of at least 100"})
 			return
 		}
-		trackIDs = append(trackIDs, uint(id))
+		intervalMeters = val
 	}
 
-	if len(trackIDs) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid track IDs provided"})
+	splits, err := h.trackService.GetSplits(c.Request.Context(), uint(id), intervalMeters)
+	if err != nil {
+		respondTrackError(c, err)
 		return
 	}
 
-	// Limit the number of tracks that can be requested at once
-	if len(trackIDs) > 50 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many track IDs requested (max 50)"})
+	c.JSON(http.StatusOK, splits)
+}
+
+// GetTrackGrade returns the gradient profile of a track
+func (h *TrackHandler) GetTrackGrade(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
 		return
 	}
 
-	coordinates, err := h.trackService.GetTrackCoordinates(trackIDs)
+	segments, err := h.trackService.GetGradeProfile(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondTrackError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, coordinates)
+	c.JSON(http.StatusOK, segments)
 }
 
-func (h *TrackHandler) DownloadTrack(c *gin.Context) {
+// GetTrackSummary returns a track without its points, for views that only show its stats
+func (h *TrackHandler) GetTrackSummary(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
@@ -232,14 +584,679 @@ func (h *TrackHandler) DownloadTrack(c *gin.Context) {
 		return
 	}
 
-	gpxData, filename, err := h.trackService.GetGPXData(uint(id))
+	track, err := h.trackService.GetTrackSummary(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondTrackError(c, err)
 		return
 	}
 
-	// Set headers for file download
-	c.Header("Content-Type", "application/gpx+xml")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Data(http.StatusOK, "application/gpx+xml", gpxData)
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	c.JSON(http.StatusOK, convertTrackUnits(*track, units))
+}
+
+// GetTrackBounds returns just a track's bounding box and centroid, for zooming the map to
+// it without fetching the track. The response is cacheable like the exports.
+func (h *TrackHandler) GetTrackBounds(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if h.notModified(c, uint(id), "bounds") {
+		return
+	}
+
+	bounds, err := h.trackService.GetTrackBounds(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, bounds)
+}
+
+// GetTracksIntersecting returns tracks crossing the WKT geometry given in ?wkt=
+func (h *TrackHandler) GetTracksIntersecting(c *gin.Context) {
+	wkt := c.Query("wkt")
+	if wkt == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing wkt parameter"})
+		return
+	}
+
+	limit, ok := h.parseLimit(c)
+	if !ok {
+		return
+	}
+
+	tracks, err := h.trackService.GetTracksIntersecting(c.Request.Context(), wkt, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWKT) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondQueryError(c, err)
+		return
+	}
+
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	c.JSON(http.StatusOK, convertTracksUnits(tracks, units))
+}
+
+// fromPointsRequest is the body of POST /tracks/from-points
+type fromPointsRequest struct {
+	Name   string `json:"name"`
+	Points []struct {
+		Latitude  float64    `json:"latitude"`
+		Longitude float64    `json:"longitude"`
+		Elevation *float64   `json:"elevation"`
+		Time      *time.Time `json:"time"`
+	} `json:"points"`
+}
+
+// CreateTrackFromPoints stores a track recorded as raw points, such as from a mobile app
+func (h *TrackHandler) CreateTrackFromPoints(c *gin.Context) {
+	var req fromPointsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	points := make([]models.TrackPoint, len(req.Points))
+	for i, point := range req.Points {
+		points[i] = models.TrackPoint{
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Elevation: point.Elevation,
+			Time:      point.Time,
+		}
+	}
+
+	track, err := h.trackService.CreateTrackFromPoints(c.Request.Context(), strings.TrimSpace(req.Name), points)
+	if err != nil {
+		if errors.Is(err, services.ErrTooFewPoints) || errors.Is(err, services.ErrInvalidCoordinate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, track)
+}
+
+// mergeTracksRequest is the body of POST /tracks/merge
+type mergeTracksRequest struct {
+	IDs           []uint `json:"ids"`
+	Name          string `json:"name"`
+	DeleteSources bool   `json:"delete_sources"`
+}
+
+// MergeTracks combines several tracks, such as one outing recorded as separate files, into
+// a new track
+func (h *TrackHandler) MergeTracks(c *gin.Context) {
+	var req mergeTracksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	if len(req.IDs) > maxTrackIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many track IDs requested (max %d)", maxTrackIDs)})
+		return
+	}
+
+	track, err := h.trackService.MergeTracks(c.Request.Context(), req.IDs, strings.TrimSpace(req.Name), req.DeleteSources)
+	if err != nil {
+		if errors.Is(err, services.ErrTooFewTracks) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		respondQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, track)
+}
+
+// GetStats returns totals across the whole dataset
+func (h *TrackHandler) GetStats(c *gin.Context) {
+	stats, err := h.trackService.GetStats(c.Request.Context())
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// updateTrackRequest lists the fields PATCH /tracks/:id accepts; anything else is rejected
+type updateTrackRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Type        *string `json:"type"`
+}
+
+// UpdateTrack edits a track's name, description or activity type
+func (h *TrackHandler) UpdateTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	// Reject unknown fields so attempts to edit computed values fail loudly
+	var req updateTrackRequest
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	if req.Name != nil {
+		trimmed := strings.TrimSpace(*req.Name)
+		if trimmed == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+			return
+		}
+		req.Name = &trimmed
+	}
+
+	track, err := h.trackService.UpdateTrackMetadata(c.Request.Context(), uint(id), req.Name, req.Description, req.Type)
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, track)
+}
+
+func (h *TrackHandler) GetTracksByBounds(c *gin.Context) {
+	bounds, err := parseRequiredBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, ok := h.parseLimit(c)
+	if !ok {
+		return
+	}
+
+	tracks, err := h.trackService.GetTracksByBounds(c.Request.Context(), bounds.North, bounds.South, bounds.East, bounds.West, limit)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	units := parseUnits(c)
+	c.Header(unitsHeader, units)
+	c.JSON(http.StatusOK, convertTracksUnits(tracks, units))
+}
+
+// GetTrackClusters returns track counts bucketed by geohash for zoomed-out map views
+func (h *TrackHandler) GetTrackClusters(c *gin.Context) {
+	bounds, err := parseRequiredBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zoom, err := strconv.Atoi(c.Query("zoom"))
+	if err != nil || zoom < 0 || zoom > 22 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "zoom must be an integer between 0 and 22"})
+		return
+	}
+
+	clusters, err := h.trackService.GetClusters(c.Request.Context(), bounds.North, bounds.South, bounds.East, bounds.West, zoom)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clusters)
+}
+
+func (h *TrackHandler) GetTracksNearby(c *gin.Context) {
+	latStr := c.Query("lat")
+	lonStr := c.Query("lon")
+
+	if latStr == "" || lonStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing location parameters (lat, lon)"})
+		return
+	}
+
+	lat, err := parseCoordinate(latStr)
+	if err != nil || lat < -90 || lat > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lat coordinate"})
+		return
+	}
+
+	lon, err := parseCoordinate(lonStr)
+	if err != nil || lon < -180 || lon > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lon coordinate"})
+		return
+	}
+
+	// Optional radius parameter (default 25km, capped by the service)
+	radiusKm := 25.0
+	if radiusStr := c.Query("radius_km"); radiusStr != "" {
+		parsedRadius, err := parseCoordinate(radiusStr)
+		if err != nil || parsedRadius <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid radius_km parameter"})
+			return
+		}
+		radiusKm = parsedRadius
+	}
+
+	limit, ok := h.parseLimit(c)
+	if !ok {
+		return
+	}
+
+	tracks, err := h.trackService.GetTracksNear(c.Request.Context(), lat, lon, radiusKm, limit)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tracks)
+}
+
+// coordinatesRequest holds the options shared by the GET and POST coordinates endpoints
+type coordinatesRequest struct {
+	IDs            []uint  `json:"ids"`
+	Tolerance      float64 `json:"tolerance"`       // simplification tolerance in meters
+	Format         string  `json:"format"`          // json (default) or polyline
+	MaxBytes       int     `json:"max_bytes"`       // byte budget for the serialized response
+	IncludeBearing bool    `json:"include_bearing"` // add per-point bearings for direction arrows
+}
+
+// validate checks the options, returning the message for a 400 response or ""
+func (r coordinatesRequest) validate() string {
+	switch {
+	case r.Tolerance < 0:
+		return "Invalid tolerance parameter"
+	case r.Format != "" && r.Format != "json" && r.Format != "polyline":
+		return "Invalid format parameter (expected json or polyline)"
+	case r.MaxBytes < 0:
+		return "Invalid max_bytes parameter"
+	case r.MaxBytes > 0 && r.Format == "polyline":
+		return "max_bytes is not supported with format=polyline"
+	case r.IncludeBearing && r.Format == "polyline":
+		return "include_bearing is not supported with format=polyline"
+	}
+	return ""
+}
+
+func (h *TrackHandler) GetTrackCoordinates(c *gin.Context) {
+	trackIDs, ok := parseTrackIDs(c)
+	if !ok {
+		return
+	}
+	req := coordinatesRequest{
+		IDs:            trackIDs,
+		Format:         c.Query("format"),
+		IncludeBearing: c.Query("include_bearing") == "true",
+	}
+
+	// Optional simplification tolerance in meters
+	if toleranceStr := c.Query("tolerance"); toleranceStr != "" {
+		val, err := strconv.ParseFloat(toleranceStr, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tolerance parameter"})
+			return
+		}
+		req.Tolerance = val
+	}
+
+	// Optional byte budget for the serialized response
+	if maxBytesStr := c.Query("max_bytes"); maxBytesStr != "" {
+		val, err := strconv.Atoi(maxBytesStr)
+		if err != nil || val <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_bytes parameter"})
+			return
+		}
+		req.MaxBytes = val
+	}
+
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	h.writeTrackCoordinates(c, req)
+}
+
+// PostTrackCoordinates is GetTrackCoordinates with the options in a JSON body, which allows
+// up to maxBulkTrackIDs tracks without running into URL length limits
+func (h *TrackHandler) PostTrackCoordinates(c *gin.Context) {
+	var req coordinatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid track IDs provided"})
+		return
+	}
+	if len(req.IDs) > maxBulkTrackIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many track IDs requested (max %d)", maxBulkTrackIDs)})
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	h.writeTrackCoordinates(c, req)
+}
+
+// writeTrackCoordinates loads the requested coordinates and writes them in the requested
+// format, keyed by track ID
+func (h *TrackHandler) writeTrackCoordinates(c *gin.Context, req coordinatesRequest) {
+	coordinates, err := h.trackService.GetTrackCoordinates(c.Request.Context(), req.IDs, req.Tolerance)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if req.IncludeBearing {
+		h.trackService.AddBearings(coordinates)
+	}
+
+	if req.MaxBytes > 0 {
+		fitted, payload, err := h.trackService.FitTrackCoordinates(coordinates, req.MaxBytes)
+		if err != nil {
+			if errors.Is(err, services.ErrPayloadTooLarge) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Tracks cannot be reduced to fit within %d bytes", req.MaxBytes)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		pointCount := 0
+		for _, points := range fitted {
+			pointCount += len(points)
+		}
+
+		c.Header("X-Payload-Bytes", strconv.Itoa(len(payload)))
+		c.Header("X-Point-Count", strconv.Itoa(pointCount))
+		c.Data(http.StatusOK, "application/json; charset=utf-8", payload)
+		return
+	}
+
+	if req.Format == "polyline" {
+		c.JSON(http.StatusOK, h.trackService.EncodeTrackPolylines(coordinates))
+		return
+	}
+
+	c.JSON(http.StatusOK, coordinates)
+}
+
+func (h *TrackHandler) DownloadTrack(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	// GPX 1.1 by default; older devices may need 1.0
+	version := c.DefaultQuery("version", services.GPXVersion11)
+	if version != services.GPXVersion10 && version != services.GPXVersion11 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be 1.0 or 1.1"})
+		return
+	}
+
+	// Decimals of coordinates; out-of-range values are clamped
+	precision := services.DefaultGPXPrecision
+	if precisionStr := c.Query("precision"); precisionStr != "" {
+		precision, err = strconv.Atoi(precisionStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "precision must be an integer"})
+			return
+		}
+		precision = min(max(precision, services.MinGPXPrecision), services.MaxGPXPrecision)
+	}
+
+	if h.notModified(c, uint(id), fmt.Sprintf("gpx-%s-%d", version, precision)) {
+		return
+	}
+
+	filename, err := h.trackService.GetGPXFilename(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	// Build the document once, so Content-Length is exactly the length of the body sent
+	// and download managers can show progress. HEAD needs the length too, but stops at the
+	// headers; the gzip middleware adjusts them to match a compressed GET.
+	var body bytes.Buffer
+	if err := h.trackService.WriteGPX(c.Request.Context(), &body, uint(id), version, precision); err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Type", "application/gpx+xml")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Content-Length", strconv.Itoa(body.Len()))
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := c.Writer.Write(body.Bytes()); err != nil {
+		log.Printf("Error sending GPX for track %d: %v", id, err)
+	}
+}
+
+func (h *TrackHandler) GetTrackGeoJSON(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if h.notModified(c, uint(id), "geojson") {
+		return
+	}
+
+	geoJSON, filename, err := h.trackService.GetGeoJSON(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, "application/geo+json", geoJSON)
+}
+
+func (h *TrackHandler) DownloadTrackKML(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if h.notModified(c, uint(id), "kml") {
+		return
+	}
+
+	kmlData, filename, err := h.trackService.GetKMLData(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Type", "application/vnd.google-earth.kml+xml")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, "application/vnd.google-earth.kml+xml", kmlData)
+}
+
+func (h *TrackHandler) DownloadTrackTCX(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if h.notModified(c, uint(id), "tcx") {
+		return
+	}
+
+	tcxData, filename, err := h.trackService.GetTCXData(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Type", "application/vnd.garmin.tcx+xml")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, "application/vnd.garmin.tcx+xml", tcxData)
+}
+
+// DownloadTrackCSV streams the track points as a CSV file for spreadsheets
+func (h *TrackHandler) DownloadTrackCSV(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	if h.notModified(c, uint(id), "csv") {
+		return
+	}
+
+	filename, err := h.trackService.GetCSVFilename(c.Request.Context(), uint(id))
+	if err != nil {
+		respondTrackError(c, err)
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Status(http.StatusOK)
+
+	// Stream the rows; once bytes are sent the status can no longer change
+	if err := h.trackService.WriteCSV(c.Request.Context(), c.Writer, uint(id)); err != nil {
+		log.Printf("Error streaming CSV for track %d: %v", id, err)
+	}
+}
+
+// DownloadTracks streams a zip archive with one GPX file per requested track
+func (h *TrackHandler) DownloadTracks(c *gin.Context) {
+	trackIDs, ok := parseTrackIDs(c)
+	if !ok {
+		return
+	}
+
+	filenames, err := h.trackService.GetGPXFilenames(c.Request.Context(), trackIDs)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if len(filenames) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tracks not found"})
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"tracks_%d.zip\"", len(filenames)))
+	c.Status(http.StatusOK)
+
+	// Stream the archive; once bytes are sent the status can no longer change
+	if err := h.trackService.WriteGPXZip(c.Request.Context(), c.Writer, trackIDs, filenames); err != nil {
+		log.Printf("Error streaming GPX archive: %v", err)
+	}
+}
+
+// parseDate accepts an RFC3339 timestamp or a YYYY-MM-DD date (midnight UTC), reporting
+// which form was used
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	return t, true, err
+}
+
+// respondTrackError reports a failed single-track lookup: 404 when the track doesn't exist,
+// 500 for genuine database errors
+func respondTrackError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Track not found"})
+		return
+	}
+	respondQueryError(c, err)
+}
+
+// respondQueryError reports a failed database query: 503 when the request deadline ran
+// out, 500 otherwise
+func respondQueryError(c *gin.Context, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request timed out"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// parseTrackIDs reads the comma-separated ids query parameter, writing a 400 response
+// and returning false when it is missing, malformed or over the limit
+func parseTrackIDs(c *gin.Context) ([]uint, bool) {
+	idsParam := c.Query("ids")
+	if idsParam == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'ids' parameter"})
+		return nil, false
+	}
+
+	idStrings := strings.Split(idsParam, ",")
+	var trackIDs []uint
+
+	for _, idStr := range idStrings {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid track ID: %s", idStr)})
+			return nil, false
+		}
+		trackIDs = append(trackIDs, uint(id))
+	}
+
+	if len(trackIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid track IDs provided"})
+		return nil, false
+	}
+
+	// Limit the number of tracks that can be requested at once
+	if len(trackIDs) > maxTrackIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many track IDs requested (max %d)", maxTrackIDs)})
+		return nil, false
+	}
+
+	return trackIDs, true
 }
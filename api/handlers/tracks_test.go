@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"mytracks-api/models"
+	"mytracks-api/services"
+)
+
+func TestGetTracksMinPoints(t *testing.T) {
+	db := testDB(t)
+	trackService := services.NewTrackService(db, "")
+	stub := createTestTrack(t, trackService, "stub.gpx")
+	long := createTestTrack(t, trackService, "long.gpx")
+	if err := db.Model(&models.GPXTrack{}).Where("id = ?", long.ID).Update("point_count", 500).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewTrackHandler(trackService)
+	w := serve(http.MethodGet, "/tracks", "/tracks?min_points=100", "", h.GetTracks)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var tracks []models.GPXTrack
+	if err := json.Unmarshal(w.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 || tracks[0].ID != long.ID {
+		t.Fatalf("got %d tracks, want only track %d; the %d-point track %d should be excluded",
+			len(tracks), long.ID, stub.PointCount, stub.ID)
+	}
+}
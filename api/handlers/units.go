@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"mytracks-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	metersPerMile        = 1609.344
+	feetPerMeter         = 3.28084
+	mphPerMeterPerSecond = 2.23694
+	unitsMetric          = "metric"
+	unitsImperial        = "imperial"
+	unitsHeader          = "X-Units"
+)
+
+// parseUnits returns the unit system requested via ?units=, defaulting to metric
+// when the parameter is absent or not recognized.
+func parseUnits(c *gin.Context) string {
+	if c.Query("units") == unitsImperial {
+		return unitsImperial
+	}
+	return unitsMetric
+}
+
+// convertTrackUnits returns a copy of the track with distances in miles, elevations in
+// feet and speeds in mph when imperial units were requested. Stored values are never
+// modified; this only shapes the response. Fields that name their unit, like
+// bounding_box_area_km2 and bounding_circle.radius_meters, stay metric in both systems.
+func convertTrackUnits(track models.GPXTrack, units string) models.GPXTrack {
+	if units != unitsImperial {
+		return track
+	}
+
+	track.Distance /= metersPerMile
+	track.Distance2D /= metersPerMile
+	track.Distance3D /= metersPerMile
+	track.ElevationGain *= feetPerMeter
+	track.ElevationLoss *= feetPerMeter
+	track.RawElevationGain *= feetPerMeter
+	track.SmoothedElevationGain *= feetPerMeter
+	track.MaxElevation *= feetPerMeter
+	track.MinElevation *= feetPerMeter
+	track.AverageSpeed *= mphPerMeterPerSecond
+	track.MaxSpeed *= mphPerMeterPerSecond
+
+	// Copy the slices so the caller's points aren't touched
+	if track.TrackPoints != nil {
+		points := make([]models.TrackPoint, len(track.TrackPoints))
+		for i, point := range track.TrackPoints {
+			if point.Elevation != nil {
+				elevation := *point.Elevation * feetPerMeter
+				point.Elevation = &elevation
+			}
+			points[i] = point
+		}
+		track.TrackPoints = points
+	}
+
+	if track.Waypoints != nil {
+		waypoints := make([]models.Waypoint, len(track.Waypoints))
+		for i, waypoint := range track.Waypoints {
+			if waypoint.Elevation != nil {
+				elevation := *waypoint.Elevation * feetPerMeter
+				waypoint.Elevation = &elevation
+			}
+			waypoints[i] = waypoint
+		}
+		track.Waypoints = waypoints
+	}
+
+	return track
+}
+
+// convertTracksUnits applies convertTrackUnits to every track in a list response
+func convertTracksUnits(tracks []models.GPXTrack, units string) []models.GPXTrack {
+	if units != unitsImperial {
+		return tracks
+	}
+
+	converted := make([]models.GPXTrack, len(tracks))
+	for i, track := range tracks {
+		converted[i] = convertTrackUnits(track, units)
+	}
+	return converted
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"mytracks-api/models"
+)
+
+func TestConvertTrackUnitsKeepsNamedMetricFields(t *testing.T) {
+	track := models.GPXTrack{
+		Distance:           1609.344,
+		BoundingBoxAreaKm2: 12.5,
+		BoundingCircle:     &models.BoundingCircle{RadiusMeters: 2000},
+	}
+
+	got := convertTrackUnits(track, unitsImperial)
+	if math.Abs(got.Distance-1) > 1e-9 {
+		t.Errorf("Distance = %v, want 1 mile", got.Distance)
+	}
+	// These fields carry their unit in the JSON name, so imperial leaves them alone
+	if got.BoundingBoxAreaKm2 != 12.5 {
+		t.Errorf("BoundingBoxAreaKm2 = %v, want 12.5", got.BoundingBoxAreaKm2)
+	}
+	if got.BoundingCircle.RadiusMeters != 2000 {
+		t.Errorf("BoundingCircle.RadiusMeters = %v, want 2000", got.BoundingCircle.RadiusMeters)
+	}
+}
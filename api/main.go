@@ -2,18 +2,25 @@ package main
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"mytracks-api/config"
 	"mytracks-api/handlers"
 	"mytracks-api/models"
 	"mytracks-api/services"
@@ -33,12 +40,25 @@ type rateLimiter struct {
 
 // Seeding progress tracking
 type SeedingProgress struct {
-	TotalTracks  int       `json:"total_tracks"`
-	LoadedTracks int       `json:"loaded_tracks"`
-	IsComplete   bool      `json:"is_complete"`
-	IsRunning    bool      `json:"is_running"`
-	ErrorMessage string    `json:"error_message,omitempty"`
-	LastUpdated  time.Time `json:"last_updated"`
+	TotalTracks               int       `json:"total_tracks"`
+	LoadedTracks              int       `json:"loaded_tracks"`
+	PercentComplete           float64   `json:"percent_complete"`
+	EstimatedSecondsRemaining *float64  `json:"estimated_seconds_remaining,omitempty"`
+	IsComplete                bool      `json:"is_complete"`
+	IsRunning                 bool      `json:"is_running"`
+	ErrorMessage              string    `json:"error_message,omitempty"`
+	FailedTracks              int       `json:"failed_tracks"`
+	FailedFilenames           []string  `json:"failed_filenames,omitempty"` // First maxFailedFilenames failures
+	CompletedWithErrors       bool      `json:"completed_with_errors"`      // Complete, but some tracks failed to load
+	Phase                     string    `json:"phase,omitempty"`            // phaseDownloading or phaseLoading while running
+	DownloadedBytes           int64     `json:"downloaded_bytes,omitempty"`
+	DownloadTotalBytes        int64     `json:"download_total_bytes,omitempty"` // -1 when the server sent no length
+	LastUpdated               time.Time `json:"last_updated"`
+
+	// Loading rate baseline, captured when IsRunning becomes true
+	startedAt   time.Time
+	startLoaded int
+	inserted    int // Tracks stored by this run, which LoadedTracks reports once it completes
 }
 
 var (
@@ -54,9 +74,12 @@ var (
 		LastUpdated:  time.Now(),
 	}
 	seedingMutex sync.RWMutex
+
+	// Held while a refresh downloads and loads a new archive, so refreshes don't overlap
+	refreshMutex sync.Mutex
 )
 
-// CountGPXFilesInTar counts the number of .gpx files in a tar.gz archive
+// CountGPXFilesInTar counts the number of GPX files, plain or gzipped, in a tar.gz archive
 func countGPXFilesInTar(tarPath string) (int, error) {
 	file, err := os.Open(tarPath)
 	if err != nil {
@@ -91,7 +114,7 @@ func countGPXFilesInTar(tarPath string) (int, error) {
 			return 0, fmt.Errorf("error reading tar: %w", err)
 		}
 
-		if header.Typeflag == tar.TypeReg && strings.HasSuffix(strings.ToLower(header.Name), ".gpx") {
+		if header.Typeflag == tar.TypeReg && isGPXName(header.Name) {
 			count++
 		}
 	}
@@ -99,97 +122,402 @@ func countGPXFilesInTar(tarPath string) (int, error) {
 	return count, nil
 }
 
-// LoadTracksFromTar loads all GPX tracks from a tar.gz file into the database
-func loadTracksFromTar(db *gorm.DB, tarPath string, gpxService *services.GPXService) error {
+// seedBatchSize is the number of parsed tracks inserted per database transaction
+const seedBatchSize = 50
+
+// defaultSeedStreamThreshold is the archive entry size above which a GPX file is parsed
+// straight from the archive stream instead of being read into memory for the workers
+const defaultSeedStreamThreshold = 1 << 20
+
+// maxFailedFilenames caps how many failed filenames SeedingProgress lists; FailedTracks
+// keeps counting past it
+const maxFailedFilenames = 100
+
+// gpxEntry is a raw GPX file read from the archive, waiting to be parsed
+type gpxEntry struct {
+	name string
+	data []byte
+}
+
+// LoadTracksFromTar loads all GPX tracks from a tar.gz file into the database.
+// Archive entries are read on one goroutine, parsed by a pool of workers and inserted in
+// batches from the calling goroutine, so the "already exists" check never races. Entries
+// larger than SEED_STREAM_THRESHOLD_BYTES are parsed on the reading goroutine directly from
+// the archive stream, so big files are never held in memory as raw bytes; the tar reader
+// can't be handed to another goroutine while it waits on Next. Individually gzipped
+// .gpx.gz entries are decompressed wherever they're parsed.
+// Canceling ctx stops loading between batches and returns ctx.Err(). loaded is the count
+// handled so far across all sources; the new count is returned. A positive maxTracks stops
+// loading once that many tracks have been handled.
+func loadTracksFromTar(ctx context.Context, db *gorm.DB, tarPath string, gpxService *services.GPXService, trackService *services.TrackService, workers, maxTracks, loaded int) (int, error) {
 	file, err := os.Open(tarPath)
 	if err != nil {
-		return fmt.Errorf("failed to open tar file: %w", err)
+		return loaded, fmt.Errorf("failed to open tar file: %w", err)
 	}
 	defer file.Close()
 
 	// Check if file is empty
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return loaded, fmt.Errorf("failed to get file info: %w", err)
 	}
 	if fileInfo.Size() == 0 {
-		return fmt.Errorf("tar file is empty")
+		return loaded, fmt.Errorf("tar file is empty")
 	}
 
 	gzReader, err := gzip.NewReader(file)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return loaded, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 	defer gzReader.Close()
 
-	tarReader := tar.NewReader(gzReader)
-	loaded := 0
+	if workers < 1 {
+		workers = 1
+	}
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading tar: %w", err)
-		}
+	entries := make(chan gpxEntry, workers*2)
+	parsed := make(chan *models.GPXTrack, workers*2)
+
+	// Stops the reader and workers early once the track limit is reached, without that
+	// looking like a shutdown to the caller
+	workCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+
+	// Read archive entries sequentially; tar readers can't be shared
+	streamThreshold := int64(config.Int("SEED_STREAM_THRESHOLD_BYTES", defaultSeedStreamThreshold))
+	var readErr error
+	go func() {
+		defer close(entries)
 
-		if header.Typeflag == tar.TypeReg && strings.HasSuffix(strings.ToLower(header.Name), ".gpx") {
-			// Read the GPX file content
-			gpxData := make([]byte, header.Size)
-			_, err := io.ReadFull(tarReader, gpxData)
+		tarReader := tar.NewReader(gzReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return
+			}
 			if err != nil {
-				log.Printf("Error reading GPX file %s: %v", header.Name, err)
-				continue
+				readErr = fmt.Errorf("error reading tar: %w", err)
+				return
 			}
 
-			// Parse the GPX data
-			track, err := gpxService.ParseGPXData(gpxData, filepath.Base(header.Name))
-			if err != nil {
-				log.Printf("Error parsing GPX file %s: %v", header.Name, err)
-				continue
+			if header.Typeflag == tar.TypeReg && isGPXName(header.Name) {
+				// Parse large files as they stream; the tar reader stops at the end of
+				// the entry. parsed isn't closed until this goroutine has returned.
+				if header.Size > streamThreshold {
+					track, err := parseGPXEntry(gpxService, header.Name, tarReader)
+					if err != nil {
+						log.Printf("Error parsing GPX file %s: %v", header.Name, err)
+						recordSeedingFailure(filepath.Base(header.Name))
+						continue
+					}
+					select {
+					case parsed <- track:
+					case <-workCtx.Done():
+						return
+					}
+					continue
+				}
+
+				// Read the GPX file content
+				gpxData := make([]byte, header.Size)
+				if _, err := io.ReadFull(tarReader, gpxData); err != nil {
+					log.Printf("Error reading GPX file %s: %v", header.Name, err)
+					recordSeedingFailure(filepath.Base(header.Name))
+					continue
+				}
+				select {
+				case entries <- gpxEntry{name: header.Name, data: gpxData}:
+				case <-workCtx.Done():
+					return
+				}
 			}
+		}
+	}()
 
-			// Check if track already exists
-			var existingTrack models.GPXTrack
-			result := db.Where("filename = ?", track.Filename).First(&existingTrack)
-			if result.Error == nil {
-				// Track already exists, skip
-				log.Printf("Track %s already exists, skipping", track.Filename)
-				loaded++
-				updateSeedingProgress(loaded, seedingProgress.TotalTracks, false, "")
-				continue
+	// Parse GPX data across the worker pool
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				track, err := parseGPXEntry(gpxService, entry.name, bytes.NewReader(entry.data))
+				if err != nil {
+					log.Printf("Error parsing GPX file %s: %v", entry.name, err)
+					recordSeedingFailure(filepath.Base(entry.name))
+					continue
+				}
+				select {
+				case parsed <- track:
+				case <-workCtx.Done():
+					return
+				}
 			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	// Insert parsed tracks in batches
+	dedupe := config.Bool("SEED_DEDUPLICATE", false)
+	total := getSeedingProgress().TotalTracks
+	batch := make([]*models.GPXTrack, 0, seedBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		before := loaded
+		loaded += insertTrackBatch(ctx, db, trackService, batch, dedupe)
+		batch = batch[:0]
+		updateSeedingProgress(loaded, total, false, "")
+
+		// Log progress every 100 tracks
+		if loaded/100 > before/100 {
+			log.Printf("Loaded %d/%d tracks...", loaded, total)
+		}
+	}
 
-			// Create the track in database
-			if err := db.Create(track).Error; err != nil {
-				log.Printf("Error creating track %s: %v", track.Filename, err)
+	limitReached := false
+	for track := range parsed {
+		if ctx.Err() != nil || limitReached {
+			// Keep draining so the workers can exit
+			continue
+		}
+		batch = append(batch, track)
+		if maxTracks > 0 && loaded+len(batch) >= maxTracks {
+			flush()
+			limitReached = true
+			stopWork()
+			log.Printf("Reached SEED_MAX_TRACKS limit of %d tracks", maxTracks)
+			continue
+		}
+		if len(batch) >= seedBatchSize {
+			flush()
+		}
+	}
+	if ctx.Err() != nil {
+		return loaded, ctx.Err()
+	}
+	flush()
+
+	// readErr is safe to read here: the reader goroutine finished before parsed was closed
+	return loaded, readErr
+}
+
+// insertTrackBatch creates the tracks that aren't already in the database in a single
+// transaction, falling back to one insert per track if the batch fails. With dedupe set,
+// tracks matching a stored route by geometry are skipped too. It returns the number of
+// tracks handled, counting skipped duplicates like the sequential loader did.
+func insertTrackBatch(ctx context.Context, db *gorm.DB, trackService *services.TrackService, tracks []*models.GPXTrack, dedupe bool) int {
+	filenames := make([]string, len(tracks))
+	for i, track := range tracks {
+		filenames[i] = track.Filename
+	}
+
+	// Check which tracks already exist
+	var existing []string
+	// Soft-deleted tracks still own their filename, and shouldn't come back on a reseed
+	db.Unscoped().Model(&models.GPXTrack{}).Where("filename IN ?", filenames).Pluck("filename", &existing)
+	seen := make(map[string]bool, len(tracks))
+	for _, filename := range existing {
+		seen[filename] = true
+	}
+
+	handled := 0
+	var pending []*models.GPXTrack
+	for _, track := range tracks {
+		if seen[track.Filename] {
+			// Track already exists, skip
+			log.Printf("Track %s already exists, skipping", track.Filename)
+			handled++
+			continue
+		}
+		seen[track.Filename] = true
+
+		// Optionally skip the same route stored under another filename
+		if dedupe {
+			duplicate, err := trackService.FindDuplicate(ctx, track)
+			if err != nil {
+				log.Printf("Error checking %s for duplicates: %v", track.Filename, err)
+			} else if duplicate != nil {
+				log.Printf("Track %s duplicates %s, skipping", track.Filename, duplicate.Filename)
+				handled++
 				continue
 			}
+		}
 
-			loaded++
-			updateSeedingProgress(loaded, seedingProgress.TotalTracks, false, "")
+		pending = append(pending, track)
+	}
 
-			// Log progress every 100 tracks
-			if loaded%100 == 0 {
-				log.Printf("Loaded %d/%d tracks...", loaded, seedingProgress.TotalTracks)
-			}
+	err := trackService.CreateTracksWithPoints(pending)
+	if err == nil {
+		recordSeedingInserted(len(pending))
+		return handled + len(pending)
+	}
+
+	// One bad track aborts the whole transaction, so retry individually
+	log.Printf("Error creating track batch, retrying individually: %v", err)
+	for _, track := range pending {
+		track.ID = 0
+		for i := range track.TrackPoints {
+			track.TrackPoints[i].ID = 0
+		}
+		for i := range track.Waypoints {
+			track.Waypoints[i].ID = 0
+		}
+		if err := trackService.CreateTrackWithPoints(track); err != nil {
+			log.Printf("Error creating track %s: %v", track.Filename, err)
+			recordSeedingFailure(track.Filename)
+			continue
 		}
+		recordSeedingInserted(1)
+		handled++
 	}
 
-	return nil
+	return handled
 }
 
+// Phases of a seeding run reported in SeedingProgress.Phase
+const (
+	phaseDownloading = "downloading"
+	phaseLoading     = "loading"
+)
+
 // updateSeedingProgress updates the seeding progress in a thread-safe manner
 func updateSeedingProgress(loaded, total int, complete bool, errorMsg string) {
 	seedingMutex.Lock()
 	defer seedingMutex.Unlock()
 
+	now := time.Now()
+	running := !complete
+	// A download reports through the same progress, so loading after one starts a new run
+	startingRun := running && (!seedingProgress.IsRunning || seedingProgress.Phase == phaseDownloading)
+	// The loader counts from zero again after the initial existing-track count, so a
+	// drop in the loaded count also resets the baseline
+	if startingRun || (running && loaded < seedingProgress.startLoaded) {
+		seedingProgress.startedAt = now
+		seedingProgress.startLoaded = loaded
+	}
+	// Failures and inserts belong to a single run
+	if startingRun {
+		seedingProgress.FailedTracks = 0
+		seedingProgress.FailedFilenames = nil
+		seedingProgress.inserted = 0
+	}
+	seedingProgress.Phase = ""
+	if running {
+		seedingProgress.Phase = phaseLoading
+	}
+
 	seedingProgress.LoadedTracks = loaded
 	seedingProgress.TotalTracks = total
 	seedingProgress.IsComplete = complete
-	seedingProgress.IsRunning = !complete
+	seedingProgress.IsRunning = running
 	seedingProgress.ErrorMessage = errorMsg
+	seedingProgress.CompletedWithErrors = complete && seedingProgress.FailedTracks > 0
+	seedingProgress.LastUpdated = now
+
+	// Percentage of the archive handled; a completed run is done even though skipped and
+	// failed files leave its loaded count below the total
+	switch {
+	case complete:
+		seedingProgress.PercentComplete = 100
+	case total > 0:
+		seedingProgress.PercentComplete = math.Min(100, float64(loaded)/float64(total)*100)
+	default:
+		seedingProgress.PercentComplete = 0
+	}
+
+	// Estimate the time remaining from the rate since loading started; there's nothing
+	// to extrapolate from until at least one track has loaded
+	seedingProgress.EstimatedSecondsRemaining = nil
+	if complete {
+		zero := 0.0
+		seedingProgress.EstimatedSecondsRemaining = &zero
+	} else if running && total > 0 {
+		loadedSinceStart := loaded - seedingProgress.startLoaded
+		elapsed := now.Sub(seedingProgress.startedAt).Seconds()
+		if loadedSinceStart > 0 && elapsed > 0 {
+			remaining := math.Max(0, float64(total-loaded)) / (float64(loadedSinceStart) / elapsed)
+			seedingProgress.EstimatedSecondsRemaining = &remaining
+		}
+	}
+}
+
+// updateDownloadProgress reports an archive download as the running phase of seeding, with
+// its percentage in PercentComplete when the total size is known
+func updateDownloadProgress(downloaded, total int64) {
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+
+	seedingProgress.Phase = phaseDownloading
+	seedingProgress.IsRunning = true
+	seedingProgress.IsComplete = false
+	seedingProgress.ErrorMessage = ""
+	seedingProgress.DownloadedBytes = downloaded
+	seedingProgress.DownloadTotalBytes = total
+	seedingProgress.PercentComplete = 0
+	if total > 0 {
+		seedingProgress.PercentComplete = math.Min(100, float64(downloaded)/float64(total)*100)
+	}
+	seedingProgress.EstimatedSecondsRemaining = nil
+	seedingProgress.LastUpdated = time.Now()
+}
+
+// endDownloadPhase clears the downloading phase when no load follows it: the download
+// failed, or the archive was already current. The previous run's counts are restored.
+func endDownloadPhase(errorMsg string) {
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+
+	if seedingProgress.Phase != phaseDownloading {
+		return
+	}
+	seedingProgress.Phase = ""
+	seedingProgress.IsRunning = false
+	seedingProgress.IsComplete = true
+	seedingProgress.ErrorMessage = errorMsg
+	seedingProgress.PercentComplete = 100
+	if seedingProgress.TotalTracks > 0 {
+		seedingProgress.PercentComplete = math.Min(100, float64(seedingProgress.LoadedTracks)/float64(seedingProgress.TotalTracks)*100)
+	}
+	seedingProgress.LastUpdated = time.Now()
+}
+
+// failSeeding ends the current run with an error. The run counts as finished, so a
+// refresh can start another one; the counts so far are kept.
+func failSeeding(errorMsg string) {
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+
+	seedingProgress.Phase = ""
+	seedingProgress.IsRunning = false
+	seedingProgress.IsComplete = true
+	seedingProgress.ErrorMessage = errorMsg
+	seedingProgress.CompletedWithErrors = false
+	seedingProgress.EstimatedSecondsRemaining = nil
+	seedingProgress.LastUpdated = time.Now()
+}
+
+// recordSeedingInserted counts tracks stored by the current run
+func recordSeedingInserted(count int) {
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+
+	seedingProgress.inserted += count
+}
+
+// recordSeedingFailure counts a track that couldn't be read, parsed or inserted
+func recordSeedingFailure(filename string) {
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+
+	seedingProgress.FailedTracks++
+	if len(seedingProgress.FailedFilenames) < maxFailedFilenames {
+		seedingProgress.FailedFilenames = append(seedingProgress.FailedFilenames, filename)
+	}
 	seedingProgress.LastUpdated = time.Now()
 }
 
@@ -198,52 +526,106 @@ func getSeedingProgress() SeedingProgress {
 	seedingMutex.RLock()
 	defer seedingMutex.RUnlock()
 
-	return *seedingProgress
+	progress := *seedingProgress
+	// Copy the slice so callers can't race with recordSeedingFailure
+	progress.FailedFilenames = append([]string(nil), seedingProgress.FailedFilenames...)
+	return progress
 }
 
-// startSeedingProcess starts the background track loading process
-func startSeedingProcess(db *gorm.DB, tarPath string) {
+// startSeedingProcess starts the background track loading process over every source in
+// gpxPath (see resolveSeedSources). The returned channel is closed once seeding finishes
+// or stops after ctx is canceled.
+func startSeedingProcess(ctx context.Context, db *gorm.DB, gpxPath string, trackService *services.TrackService) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		log.Println("Starting track seeding process...")
 
-		// Count total tracks in tar.gz
-		totalTracks, err := countGPXFilesInTar(tarPath)
+		sources, err := resolveSeedSources(gpxPath)
 		if err != nil {
-			log.Printf("Error counting tracks in tar.gz: %v", err)
-			updateSeedingProgress(0, 0, false, fmt.Sprintf("Error counting tracks: %v", err))
+			log.Printf("Error reading GPX sources: %v", err)
+			failSeeding(fmt.Sprintf("Error reading GPX sources: %v", err))
 			return
 		}
 
-		log.Printf("Found %d GPX files in tar.gz", totalTracks)
-
-		// Check how many tracks are already in database
-		var existingCount int64
-		db.Model(&models.GPXTrack{}).Count(&existingCount)
-		log.Printf("Found %d existing tracks in database", existingCount)
+		// Count total tracks across the archives and loose files, failing fast on a file
+		// that isn't an archive at all
+		totalTracks := len(sources.files)
+		for _, tarPath := range sources.archives {
+			if err := services.ValidateGPXArchive(tarPath); err != nil {
+				log.Printf("Invalid GPX archive: %v", err)
+				failSeeding(fmt.Sprintf("Invalid GPX archive: %v", err))
+				return
+			}
 
-		// If we already have all tracks, mark as complete
-		if int(existingCount) >= totalTracks {
-			log.Println("All tracks already loaded, seeding complete")
-			updateSeedingProgress(totalTracks, totalTracks, true, "")
+			count, err := countGPXFilesInTar(tarPath)
+			if err != nil {
+				log.Printf("Error counting tracks in %s: %v", tarPath, err)
+				failSeeding(fmt.Sprintf("Error counting tracks: %v", err))
+				return
+			}
+			log.Printf("Found %d GPX files in %s", count, tarPath)
+			totalTracks += count
+		}
+		if totalTracks == 0 {
+			log.Printf("No .gpx files found in %s", gpxPath)
+			failSeeding(fmt.Sprintf("Invalid GPX source: %s contains no .gpx files", gpxPath))
 			return
 		}
 
-		// Initialize progress tracking
-		updateSeedingProgress(int(existingCount), totalTracks, false, "")
+		log.Printf("Found %d GPX files in total (%d loose)", totalTracks, len(sources.files))
+
+		// Optionally load only the first tracks of the archive, for small test and
+		// staging environments; the progress total reflects the limit
+		maxTracks := config.Int("SEED_MAX_TRACKS", 0)
+		if maxTracks > 0 && maxTracks < totalTracks {
+			log.Printf("Limiting seeding to %d tracks (SEED_MAX_TRACKS)", maxTracks)
+			totalTracks = maxTracks
+		}
+
+		// Initialize progress tracking. Every file is visited; insertTrackBatch skips the
+		// ones whose filename is already stored, so a refreshed archive's new files load
+		// however many tracks the database holds.
+		updateSeedingProgress(0, totalTracks, false, "")
 
-		// Load tracks from tar.gz
+		// Load the archives in order, parsing on one worker per CPU by default, then
+		// the loose files
 		gpxService := services.NewGPXService()
-		err = loadTracksFromTar(db, tarPath, gpxService)
+		workers := config.Int("SEED_WORKERS", runtime.NumCPU())
+		loaded := 0
+		for _, tarPath := range sources.archives {
+			if maxTracks > 0 && loaded >= maxTracks {
+				break
+			}
+			loaded, err = loadTracksFromTar(ctx, db, tarPath, gpxService, trackService, workers, maxTracks, loaded)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil && len(sources.files) > 0 {
+			loaded, err = loadGPXFiles(ctx, db, sources.files, gpxService, trackService, maxTracks, loaded)
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Track seeding stopped by shutdown after loading %d/%d tracks", getSeedingProgress().LoadedTracks, totalTracks)
+			return
+		}
 		if err != nil {
 			log.Printf("Error loading tracks: %v", err)
-			updateSeedingProgress(0, totalTracks, false, fmt.Sprintf("Error loading tracks: %v", err))
+			failSeeding(fmt.Sprintf("Error loading tracks: %v", err))
 			return
 		}
 
-		// Mark as complete
-		log.Println("Track seeding completed successfully")
-		updateSeedingProgress(totalTracks, totalTracks, true, "")
+		// Mark as complete with the tracks actually stored; files that were already loaded,
+		// skipped as duplicates or failed don't count as loaded
+		progress := getSeedingProgress()
+		if progress.FailedTracks > 0 {
+			log.Printf("Track seeding completed: %d tracks loaded, %d failed", progress.inserted, progress.FailedTracks)
+		} else {
+			log.Printf("Track seeding completed successfully: %d tracks loaded", progress.inserted)
+		}
+		updateSeedingProgress(progress.inserted, totalTracks, true, "")
 	}()
+	return done
 }
 
 // Clean up old rate limiters periodically
@@ -261,15 +643,14 @@ func cleanupRateLimiters() {
 }
 
 // Get or create rate limiter for IP
-func getRateLimiter(ip string) *rate.Limiter {
+func getRateLimiter(ip string, rps rate.Limit, burst int) *rate.Limiter {
 	rateLimiterMutex.Lock()
 	defer rateLimiterMutex.Unlock()
 
 	rl, exists := rateLimiters[ip]
 	if !exists {
-		// Allow 10 requests per second with burst of 20
 		rateLimiters[ip] = &rateLimiter{
-			limiter:  rate.NewLimiter(10, 20),
+			limiter:  rate.NewLimiter(rps, burst),
 			lastSeen: time.Now(),
 		}
 		return rateLimiters[ip].limiter
@@ -280,10 +661,16 @@ func getRateLimiter(ip string) *rate.Limiter {
 }
 
 // Rate limiting middleware
-func rateLimitMiddleware() gin.HandlerFunc {
+func rateLimitMiddleware(rps rate.Limit, burst int, disabled bool) gin.HandlerFunc {
+	if disabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
-		limiter := getRateLimiter(ip)
+		limiter := getRateLimiter(ip, rps, burst)
 
 		if !limiter.Allow() {
 			c.JSON(http.StatusTooManyRequests, gin.H{
@@ -297,6 +684,20 @@ func rateLimitMiddleware() gin.HandlerFunc {
 	}
 }
 
+// setTrustedProxies makes the router honor X-Forwarded-For only on requests from the
+// proxies in spec, so the rate limiter keys off the real client IP without letting clients
+// spoof it. spec is a comma-separated list of IPs or CIDRs; empty trusts none.
+func setTrustedProxies(r *gin.Engine, spec string) error {
+	r.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	var trustedProxies []string
+	for _, proxy := range strings.Split(spec, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	return r.SetTrustedProxies(trustedProxies)
+}
+
 func main() {
 	// Get configuration from environment
 	databaseURL := os.Getenv("DATABASE_URL")
@@ -309,20 +710,48 @@ func main() {
 		port = "8080"
 	}
 
+	// GPX_PATH is one archive, or a comma-separated list of archives and directories
 	gpxPath := "/app/data/gpx_files.tar.gz"
 	if envPath := os.Getenv("GPX_PATH"); envPath != "" {
 		gpxPath = envPath
 	}
+	singleArchive := isSingleArchive(gpxPath)
+
+	// Rate limiting defaults to 10 requests per second with a burst of 20
+	rateLimitRPS := rate.Limit(config.Float("RATE_LIMIT_RPS", 10))
+	rateLimitBurst := config.Int("RATE_LIMIT_BURST", 20)
+	rateLimitDisabled := config.Bool("RATE_LIMIT_DISABLED", false)
+	if rateLimitRPS <= 0 || rateLimitBurst <= 0 {
+		log.Printf("Warning: rate limit values must be positive, using defaults")
+		rateLimitRPS, rateLimitBurst = 10, 20
+	}
+	if rateLimitDisabled {
+		log.Println("Rate limiting is disabled")
+	}
 
 	s3URL := os.Getenv("GPX_S3_URL")
 	if s3URL == "" {
 		s3URL = "https://s3.us-west-2.amazonaws.com/app2.triptracks.io/gpx_files.tar.gz"
 	}
 
-	// Ensure GPX archive is available (download from S3 if needed)
+	// Ensure GPX archive is available (download from S3 if needed); local directories and
+	// archive lists are used as they are. With GPX_CACHE_DIR the archive is kept there,
+	// one file per version of the S3 object, instead of at GPX_PATH.
 	downloadService := services.NewDownloadService()
-	if err := downloadService.EnsureGPXArchive(gpxPath, s3URL); err != nil {
-		log.Fatal("Failed to ensure GPX archive availability:", err)
+	downloadService.SetProgressFunc(updateDownloadProgress)
+	// Optional SHA-256 of the archive for integrity verification after download
+	s3SHA256 := os.Getenv("GPX_S3_SHA256")
+	cacheDir := os.Getenv("GPX_CACHE_DIR")
+	if singleArchive {
+		if cacheDir != "" {
+			cachedPath, _, err := downloadService.FetchCachedArchive(cacheDir, s3URL, s3SHA256)
+			if err != nil {
+				log.Fatal("Failed to ensure GPX archive availability:", err)
+			}
+			gpxPath = cachedPath
+		} else if err := downloadService.EnsureGPXArchive(gpxPath, s3URL, s3SHA256); err != nil {
+			log.Fatal("Failed to ensure GPX archive availability:", err)
+		}
 	}
 
 	// Connect to database
@@ -336,17 +765,37 @@ func main() {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Optional PostGIS route geometries for exact intersection queries
+	if config.Bool("USE_POSTGIS", false) {
+		if err := models.MigratePostGIS(db); err != nil {
+			log.Fatal("Failed to set up PostGIS:", err)
+		}
+	}
+
 	// Initialize services
 	trackService := services.NewTrackService(db, gpxPath)
 
-	// Start background goroutine to populate missing geohashes
-	go trackService.PopulateMissingGeohashes()
+	// No region provider is configured by default, so regions stay empty offline
+	geocodeService := services.NewGeocodeService(nil)
+
+	// Start background goroutine to populate missing geohashes, then regions
+	go func() {
+		trackService.PopulateMissingGeohashes()
+		trackService.PopulateMissingRegions(geocodeService)
+	}()
 
 	// Start background cleanup for rate limiters
 	go cleanupRateLimiters()
 
-	// Start track seeding process
-	startSeedingProcess(db, gpxPath)
+	// Start track seeding process; canceled on shutdown. Refreshes seed again later, and
+	// shutdown waits for whichever run is in progress.
+	seedCtx, cancelSeeding := context.WithCancel(context.Background())
+	var seeding sync.WaitGroup
+	seeding.Add(1)
+	go func() {
+		defer seeding.Done()
+		<-startSeedingProcess(seedCtx, db, gpxPath, trackService)
+	}()
 
 	// Initialize handlers
 	trackHandler := handlers.NewTrackHandler(trackService)
@@ -354,80 +803,76 @@ func main() {
 	// Setup Gin router
 	r := gin.Default()
 
-	// Configure CORS
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "X-Requested-With"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD", "PATCH"}
-	config.AllowCredentials = true
-	config.ExposeHeaders = []string{"Content-Length", "Content-Type"}
-	r.Use(cors.New(config))
-
-	// Add explicit OPTIONS handler for preflight requests
-	r.OPTIONS("/*path", func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, HEAD, PATCH")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Length, Content-Type, Authorization, Accept, X-Requested-With")
-		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Status(200)
-	})
-
-	// Add CORS debugging middleware
-	r.Use(func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
-		method := c.Request.Method
-		path := c.Request.URL.Path
+	if err := setTrustedProxies(r, os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
-		// Log CORS requests for debugging
-		if origin != "" {
-			log.Printf("CORS request: %s %s from origin: %s", method, path, origin)
+	// Configure CORS from the CORS_ORIGINS allowlist (comma-separated). Matching request
+	// origins are reflected back; "*" allows any origin but, per the spec, without
+	// credentials, and is meant for development only.
+	corsConfig := cors.DefaultConfig()
+	corsOrigins := os.Getenv("CORS_ORIGINS")
+	if corsOrigins == "" {
+		corsOrigins = "http://localhost:2850"
+	}
+	var allowedOrigins []string
+	for _, origin := range strings.Split(corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
 		}
+	}
+	if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
+		log.Println("Warning: CORS allows all origins")
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = allowedOrigins
+		corsConfig.AllowCredentials = true
+	}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "X-Requested-With", "If-None-Match", "If-Modified-Since"}
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD", "PATCH"}
+	corsConfig.ExposeHeaders = []string{"Content-Length", "Content-Type", "ETag", "Last-Modified", "X-Limit", "X-Payload-Bytes", "X-Point-Count", "X-Units"}
+	if err := corsConfig.Validate(); err != nil {
+		log.Fatal("Invalid CORS_ORIGINS:", err)
+	}
+	r.Use(cors.New(corsConfig))
 
-		// Always set CORS headers
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, HEAD, PATCH")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Length, Content-Type, Authorization, Accept, X-Requested-With")
-		c.Header("Access-Control-Allow-Credentials", "true")
+	// Compress large responses; registered after CORS so preflight replies stay untouched
+	r.Use(gzipMiddleware(config.Int("GZIP_MIN_SIZE", 1024)))
+	r.Use(gunzipRequestMiddleware())
 
-		if method == "OPTIONS" {
-			c.Status(200)
-			return
-		}
+	// Add rate limiting middleware
+	r.Use(rateLimitMiddleware(rateLimitRPS, rateLimitBurst, rateLimitDisabled))
 
-		c.Next()
-	})
+	// Bound every request, so a slow query can't hold a connection indefinitely
+	r.Use(timeoutMiddleware(time.Duration(config.Int("REQUEST_TIMEOUT_SECONDS", 30)) * time.Second))
 
-	// Add rate limiting middleware
-	r.Use(rateLimitMiddleware())
+	// Health check endpoint; pings the database with a short timeout so it is safe to
+	// use as a readiness probe
+	r.GET("/health", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
 
-	// Add timeout middleware only for external operations (not database queries)
-	r.Use(func(c *gin.Context) {
-		// Only apply timeout to refresh endpoint which downloads from S3
-		if c.Request.URL.Path == "/tracks/refresh" {
-			ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
-			defer cancel()
-			c.Request = c.Request.WithContext(ctx)
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil {
+			log.Printf("Health check database ping failed: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded", "database": "unreachable"})
+			return
 		}
-		c.Next()
-	})
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	// Environment variables endpoint
-	r.GET("/env-vars", func(c *gin.Context) {
-		envVars := make(map[string]string)
-		for _, env := range os.Environ() {
-			// Split on first '=' to handle values that contain '='
-			parts := strings.SplitN(env, "=", 2)
-			if len(parts) == 2 {
-				envVars[parts[0]] = parts[1]
-			}
-		}
-		c.JSON(200, gin.H{"environment_variables": envVars})
-	})
+	// Environment variables endpoint, for debugging deployments. Disabled unless
+	// ENABLE_ENV_ENDPOINT=true, and credentials are redacted even then.
+	if config.Bool("ENABLE_ENV_ENDPOINT", false) {
+		log.Println("Warning: /env-vars endpoint is enabled")
+		r.GET("/env-vars", func(c *gin.Context) {
+			c.JSON(200, gin.H{"environment_variables": redactedEnvironment(os.Environ())})
+		})
+	}
 
 	// Seeding progress endpoint
 	r.GET("/seeding-progress", func(c *gin.Context) {
@@ -435,21 +880,112 @@ func main() {
 		c.JSON(200, progress)
 	})
 
+	refreshArchivePath := gpxPath
+
+	// Refresh endpoint: re-downloads the archive if the S3 copy changed and loads any new
+	// tracks in the background
+	r.POST("/tracks/refresh", refreshHandler(singleArchive, &seeding, func() {
+		// A cached archive's new version lands in a new file; refreshArchivePath is
+		// only touched while refreshMutex is held
+		archivePath := refreshArchivePath
+		var updated bool
+		var err error
+		if cacheDir != "" {
+			archivePath, updated, err = downloadService.FetchCachedArchive(cacheDir, s3URL, s3SHA256)
+		} else {
+			updated, err = downloadService.RefreshGPXArchive(archivePath, s3URL, s3SHA256)
+		}
+		if err != nil {
+			log.Printf("Error refreshing GPX archive: %v", err)
+			endDownloadPhase(fmt.Sprintf("Error refreshing GPX archive: %v", err))
+			return
+		}
+		if !updated {
+			endDownloadPhase("")
+			return
+		}
+		refreshArchivePath = archivePath
+		<-startSeedingProcess(seedCtx, db, archivePath, trackService)
+	}))
+
 	// API routes
 	api := r.Group("/")
 	{
+		api.GET("/stats", trackHandler.GetStats)
+
 		// Track routes
 		api.GET("/tracks", trackHandler.GetTracks)
 		api.GET("/tracks/bounds", trackHandler.GetTracksByBounds)
+		api.GET("/tracks/nearby", trackHandler.GetTracksNearby)
+		api.GET("/tracks/clusters", trackHandler.GetTrackClusters)
+		api.GET("/tracks/intersecting", trackHandler.GetTracksIntersecting)
 		api.GET("/track_coordinates", trackHandler.GetTrackCoordinates)
+		api.POST("/track_coordinates", trackHandler.PostTrackCoordinates)
+		api.GET("/tracks/download", trackHandler.DownloadTracks)
+		api.POST("/tracks/from-points", trackHandler.CreateTrackFromPoints)
+		api.POST("/tracks/merge", trackHandler.MergeTracks)
 		api.GET("/tracks/:id", trackHandler.GetTrack)
+		api.PATCH("/tracks/:id", trackHandler.UpdateTrack)
+		api.DELETE("/tracks/:id", trackHandler.DeleteTrack)
+		api.GET("/tracks/:id/summary", trackHandler.GetTrackSummary)
+		api.GET("/tracks/:id/bounds", trackHandler.GetTrackBounds)
+		api.GET("/tracks/:id/points", trackHandler.GetTrackPoints)
+		api.POST("/tracks/:id/restore", trackHandler.RestoreTrack)
+		api.POST("/tracks/:id/split", trackHandler.SplitTrack)
+		api.POST("/tracks/:id/reverse", trackHandler.ReverseTrack)
 		api.GET("/tracks/:id/download", trackHandler.DownloadTrack)
+		api.HEAD("/tracks/:id/download", trackHandler.DownloadTrack)
+		api.GET("/tracks/:id/geojson", trackHandler.GetTrackGeoJSON)
+		api.GET("/tracks/:id/kml", trackHandler.DownloadTrackKML)
+		api.GET("/tracks/:id/tcx", trackHandler.DownloadTrackTCX)
+		api.GET("/tracks/:id/csv", trackHandler.DownloadTrackCSV)
+		api.GET("/tracks/:id/duplicates", trackHandler.GetTrackDuplicates)
+		api.GET("/tracks/:id/neighbors", trackHandler.GetTrackNeighbors)
+		api.GET("/tracks/:id/splits", trackHandler.GetTrackSplits)
+		api.GET("/tracks/:id/grade", trackHandler.GetTrackGrade)
+	}
+
+	server := &http.Server{
+		Addr:    "0.0.0.0:" + port,
+		Handler: r,
 	}
 
 	// Start server
 	log.Printf("Starting server on port %s", port)
 	log.Printf("GPX files source: %s", gpxPath)
-	if err := r.Run("0.0.0.0:" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Wait for a termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
+	log.Printf("Received %s, shutting down...", sig)
+
+	// Let in-flight requests finish and stop seeding between batches
+	shutdownTimeout := time.Duration(config.Int("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	cancelSeeding()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error during server shutdown: %v", err)
 	}
+
+	seedingDone := make(chan struct{})
+	go func() {
+		seeding.Wait()
+		close(seedingDone)
+	}()
+	select {
+	case <-seedingDone:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for track seeding to stop")
+	}
+
+	progress := getSeedingProgress()
+	log.Printf("Server stopped; %d/%d tracks loaded", progress.LoadedTracks, progress.TotalTracks)
 }
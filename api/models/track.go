@@ -7,32 +7,63 @@ import (
 )
 
 type GPXTrack struct {
-	ID            uint         `json:"id" gorm:"primaryKey"`
-	Filename      string       `json:"filename" gorm:"uniqueIndex;not null"`
-	Name          string       `json:"name"`
-	Description   *string      `json:"description"`
-	Type          *string      `json:"type"`           // Track type (hiking, cycling, running, etc.)
-	Keywords      *string      `json:"keywords"`       // Keywords/tags for the track
-	Distance      float64      `json:"distance"`       // in meters
-	Duration      int          `json:"duration"`       // in seconds
-	ElevationGain float64      `json:"elevation_gain"` // in meters
-	ElevationLoss float64      `json:"elevation_loss"` // in meters
-	MaxElevation  float64      `json:"max_elevation"`  // in meters
-	MinElevation  float64      `json:"min_elevation"`  // in meters
-	StartTime     *time.Time   `json:"start_time"`
-	EndTime       *time.Time   `json:"end_time"`
-	Bounds        Bounds       `json:"bounds" gorm:"embedded"`
-	Geohash       string       `json:"geohash" gorm:"index"` // Geohash of track centroid for spatial indexing
-	TrackPoints   []TrackPoint `json:"track_points" gorm:"foreignKey:TrackID"`
-	CreatedAt     time.Time    `json:"created_at"`
-	UpdatedAt     time.Time    `json:"updated_at"`
+	ID                    uint            `json:"id" gorm:"primaryKey"`
+	Filename              string          `json:"filename" gorm:"uniqueIndex;not null"`
+	Name                  string          `json:"name"`
+	Description           *string         `json:"description"`
+	Type                  *string         `json:"type"`                    // Track type (hiking, cycling, running, etc.)
+	Keywords              *string         `json:"keywords"`                // Keywords/tags for the track
+	Creator               *string         `json:"creator"`                 // Creator attribute of the source file
+	Author                *string         `json:"author"`                  // Author name from the source file metadata
+	MetadataTime          *time.Time      `json:"metadata_time"`           // File-level <time> from the source metadata
+	Distance              float64         `json:"distance"`                // in meters; 2D or 3D depending on DISTANCE_MODE
+	Duration              int             `json:"duration"`                // in seconds
+	MovingTime            int             `json:"moving_time"`             // in seconds, excluding pauses
+	ElevationGain         float64         `json:"elevation_gain"`          // in meters
+	ElevationLoss         float64         `json:"elevation_loss"`          // in meters
+	RawElevationGain      float64         `json:"raw_elevation_gain"`      // in meters, before smoothing
+	SmoothedElevationGain float64         `json:"smoothed_elevation_gain"` // in meters, after smoothing
+	MaxElevation          float64         `json:"max_elevation"`           // in meters
+	MinElevation          float64         `json:"min_elevation"`           // in meters
+	AverageSpeed          float64         `json:"average_speed"`           // in m/s
+	MaxSpeed              float64         `json:"max_speed"`               // in m/s
+	PointCount            int             `json:"point_count" gorm:"index"`
+	Distance2D            float64         `json:"distance_2d" gorm:"column:distance_2d"` // in meters, over the ground
+	Distance3D            float64         `json:"distance_3d" gorm:"column:distance_3d"` // in meters, including elevation changes
+	FilteredPointCount    int             `json:"filtered_point_count"`                  // Points left out of distance and speeds as GPS drift
+	DroppedPointCount     int             `json:"dropped_point_count"`                   // Points discarded during parsing for invalid coordinates
+	HasTimestamps         bool            `json:"has_timestamps"`                        // False when no point had a time; duration and speeds are then meaningless
+	SourceTrackCount      int             `json:"source_track_count" gorm:"default:1"`   // Number of <trk> elements merged into this record
+	StartTime             *time.Time      `json:"start_time"`
+	EndTime               *time.Time      `json:"end_time"`
+	Bounds                Bounds          `json:"bounds" gorm:"embedded"`
+	BoundingBoxAreaKm2    float64         `json:"bounding_box_area_km2" gorm:"column:bounding_box_area_km2"` // Area of Bounds on the sphere
+	BoundingCircle        *BoundingCircle `json:"bounding_circle,omitempty" gorm:"embedded;embeddedPrefix:bounding_circle_"`
+	CentroidLat           float64         `json:"centroid_lat"` // Mean position of the track points
+	CentroidLon           float64         `json:"centroid_lon"`
+	Geohash               string          `json:"geohash" gorm:"index"` // Geohash of track centroid for spatial indexing
+	Region                string          `json:"region" gorm:"index"`  // Coarse location label from the GeocodeService
+	TrackPoints           []TrackPoint    `json:"track_points" gorm:"foreignKey:TrackID"`
+	Waypoints             []Waypoint      `json:"waypoints" gorm:"foreignKey:TrackID"`
+	CreatedAt             time.Time       `json:"created_at"`
+	UpdatedAt             time.Time       `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt  `json:"deleted_at,omitempty" gorm:"index"` // Set by soft deletes; excluded from queries unless Unscoped
 }
 
+// Bounds is indexed in latitude and longitude pairs to serve the precise bounds filter
 type Bounds struct {
-	North float64 `json:"north"`
-	South float64 `json:"south"`
-	East  float64 `json:"east"`
-	West  float64 `json:"west"`
+	North float64 `json:"north" gorm:"index:idx_gpx_tracks_lat_bounds,priority:1"`
+	South float64 `json:"south" gorm:"index:idx_gpx_tracks_lat_bounds,priority:2"`
+	East  float64 `json:"east" gorm:"index:idx_gpx_tracks_lon_bounds,priority:1"`
+	West  float64 `json:"west" gorm:"index:idx_gpx_tracks_lon_bounds,priority:2"`
+}
+
+// BoundingCircle is centered on the track centroid (CentroidLat/CentroidLon, the mean of
+// the points) with the radius reaching the farthest point
+type BoundingCircle struct {
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	RadiusMeters float64 `json:"radius_meters"`
 }
 
 type TrackPoint struct {
@@ -45,6 +76,19 @@ type TrackPoint struct {
 	CreatedAt time.Time  `json:"created_at"`
 }
 
+// Waypoint is a named point of interest stored alongside a track
+type Waypoint struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	TrackID     uint      `json:"track_id" gorm:"index"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	Elevation   *float64  `json:"elevation"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	Symbol      *string   `json:"symbol"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 func (GPXTrack) TableName() string {
 	return "gpx_tracks"
 }
@@ -53,6 +97,77 @@ func (TrackPoint) TableName() string {
 	return "track_points"
 }
 
+func (Waypoint) TableName() string {
+	return "waypoints"
+}
+
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(&GPXTrack{}, &TrackPoint{})
+	if err := db.AutoMigrate(&GPXTrack{}, &TrackPoint{}, &Waypoint{}); err != nil {
+		return err
+	}
+
+	// Indexes GORM tags can't express. The full-text search vector over the text fields is
+	// maintained by Postgres; it isn't a struct field, so GORM leaves it alone later on.
+	migrations := []string{
+		`ALTER TABLE gpx_tracks ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('english',
+				coalesce(name, '') || ' ' || coalesce(description, '') || ' ' || coalesce(filename, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_gpx_tracks_search_vector ON gpx_tracks USING GIN (search_vector)`,
+		// The plain geohash index can't serve "geohash LIKE 'prefix%'" under a non-C
+		// collation; text_pattern_ops makes prefix matches index scans
+		`CREATE INDEX IF NOT EXISTS idx_gpx_tracks_geohash_pattern ON gpx_tracks (geohash text_pattern_ops)`,
+		// Backfill the bounding box area of rows stored before it was computed; matches
+		// boundingBoxArea in the parser
+		`UPDATE gpx_tracks SET bounding_box_area_km2 =
+			6371.0 * 6371.0 * radians(east - west) * abs(sin(radians(north)) - sin(radians(south)))
+			WHERE bounding_box_area_km2 = 0 AND north <> south AND east > west`,
+		// Backfill centroids of rows stored before they were computed, averaging the
+		// points as unit vectors like trackCentroid in the parser
+		`UPDATE gpx_tracks SET (centroid_lat, centroid_lon) = (
+			SELECT degrees(atan2(z, sqrt(x * x + y * y))), degrees(atan2(y, x))
+			FROM (SELECT AVG(cos(radians(latitude)) * cos(radians(longitude))) AS x,
+					AVG(cos(radians(latitude)) * sin(radians(longitude))) AS y,
+					AVG(sin(radians(latitude))) AS z
+				FROM track_points WHERE track_points.track_id = gpx_tracks.id) AS mean)
+			WHERE centroid_lat = 0 AND centroid_lon = 0
+				AND EXISTS (SELECT 1 FROM track_points WHERE track_points.track_id = gpx_tracks.id)`,
+		// Rows stored before 2D distance was kept separately measured Distance over the ground
+		`UPDATE gpx_tracks SET distance_2d = distance WHERE distance_2d = 0 AND distance > 0`,
+		// Rows stored before HasTimestamps was recorded; StartTime is set exactly when a
+		// point had a time
+		`UPDATE gpx_tracks SET has_timestamps = true WHERE start_time IS NOT NULL AND NOT has_timestamps`,
+		// Backfill the point count of rows stored before it was recorded. Tracks that
+		// really have no points are simply recounted.
+		`UPDATE gpx_tracks SET point_count =
+			(SELECT COUNT(*) FROM track_points WHERE track_points.track_id = gpx_tracks.id)
+			WHERE point_count = 0`,
+	}
+	for _, migration := range migrations {
+		if err := db.Exec(migration).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigratePostGIS enables the PostGIS extension and adds the route line geometry of each
+// track with a GiST index, backfilling routes of existing tracks from their points. It is
+// only run with USE_POSTGIS=true, and needs a database image that ships PostGIS.
+func MigratePostGIS(db *gorm.DB) error {
+	migrations := []string{
+		`CREATE EXTENSION IF NOT EXISTS postgis`,
+		`ALTER TABLE gpx_tracks ADD COLUMN IF NOT EXISTS route geometry(LineString, 4326)`,
+		`CREATE INDEX IF NOT EXISTS idx_gpx_tracks_route ON gpx_tracks USING GIST (route)`,
+		`UPDATE gpx_tracks SET route = lines.route
+			FROM (SELECT track_id, ST_MakeLine(ST_SetSRID(ST_MakePoint(longitude, latitude), 4326) ORDER BY id) AS route
+				FROM track_points GROUP BY track_id HAVING COUNT(*) >= 2) AS lines
+			WHERE gpx_tracks.id = lines.track_id AND gpx_tracks.route IS NULL`,
+	}
+	for _, migration := range migrations {
+		if err := db.Exec(migration).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter allows one request per client and no refill, behind the given proxies
+func limitedRouter(t *testing.T, trustedProxies string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := setTrustedProxies(r, trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(rateLimitMiddleware(0, 1, false))
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	return r
+}
+
+// resetRateLimiters forgets every client's bucket
+func resetRateLimiters() {
+	rateLimiterMutex.Lock()
+	rateLimiters = make(map[string]*rateLimiter)
+	rateLimiterMutex.Unlock()
+}
+
+// ping sends a request from remoteAddr claiming to be forwarded for forwardedFor
+func ping(r *gin.Engine, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	resetRateLimiters()
+	r := limitedRouter(t, "")
+
+	// A client rotating X-Forwarded-For still shares its own bucket
+	if code := ping(r, "198.51.100.7:1234", "203.0.113.1"); code != http.StatusOK {
+		t.Fatalf("first request: status = %d", code)
+	}
+	if code := ping(r, "198.51.100.7:1234", "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed second request: status = %d, want 429", code)
+	}
+}
+
+func TestRateLimitUsesForwardedForFromTrustedProxy(t *testing.T) {
+	resetRateLimiters()
+	r := limitedRouter(t, "10.0.0.0/8")
+
+	// Two clients behind the load balancer get separate buckets
+	if code := ping(r, "10.0.0.5:1234", "203.0.113.1"); code != http.StatusOK {
+		t.Fatalf("first client: status = %d", code)
+	}
+	if code := ping(r, "10.0.0.5:1234", "203.0.113.2"); code != http.StatusOK {
+		t.Fatalf("second client: status = %d, want its own bucket", code)
+	}
+	if code := ping(r, "10.0.0.5:1234", "203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("first client again: status = %d, want 429", code)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refreshHandler serves POST /tracks/refresh. refresh downloads the archive again and seeds
+// any new tracks; it runs in the background with refreshMutex held, counted in seeding so
+// shutdown waits for it. The reply is the current progress, sent straight away; clients
+// poll /seeding-progress from there. A run that ended with an error doesn't block a refresh.
+func refreshHandler(singleArchive bool, seeding *sync.WaitGroup, refresh func()) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !singleArchive {
+			c.JSON(http.StatusConflict, gin.H{"error": "Refresh needs GPX_PATH to name a single archive"})
+			return
+		}
+		if !refreshMutex.TryLock() {
+			c.JSON(http.StatusConflict, gin.H{"error": "A refresh is already running"})
+			return
+		}
+		if getSeedingProgress().IsRunning {
+			refreshMutex.Unlock()
+			c.JSON(http.StatusConflict, gin.H{"error": "Track seeding is already running"})
+			return
+		}
+
+		seeding.Add(1)
+		go func() {
+			defer seeding.Done()
+			defer refreshMutex.Unlock()
+			refresh()
+		}()
+
+		c.JSON(http.StatusAccepted, getSeedingProgress())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resetSeedingProgress puts the package-level progress back to its initial state
+func resetSeedingProgress(t *testing.T) {
+	t.Helper()
+	seedingMutex.Lock()
+	seedingProgress = &SeedingProgress{}
+	seedingMutex.Unlock()
+}
+
+func postRefresh(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/tracks/refresh", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tracks/refresh", nil))
+	return w
+}
+
+func TestRefreshAfterFailedSeed(t *testing.T) {
+	resetSeedingProgress(t)
+	updateSeedingProgress(0, 10, false, "")
+	failSeeding("Invalid GPX archive: not a gzip file")
+
+	progress := getSeedingProgress()
+	if progress.IsRunning || !progress.IsComplete {
+		t.Fatalf("failed seed left IsRunning=%v IsComplete=%v", progress.IsRunning, progress.IsComplete)
+	}
+	if progress.ErrorMessage != "Invalid GPX archive: not a gzip file" {
+		t.Fatalf("ErrorMessage = %q", progress.ErrorMessage)
+	}
+
+	var seeding sync.WaitGroup
+	refreshed := false
+	w := postRefresh(refreshHandler(true, &seeding, func() { refreshed = true }))
+	seeding.Wait()
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if !refreshed {
+		t.Fatal("refresh didn't run")
+	}
+}
+
+func TestRefreshWhileSeeding(t *testing.T) {
+	resetSeedingProgress(t)
+	updateSeedingProgress(3, 10, false, "")
+
+	var seeding sync.WaitGroup
+	w := postRefresh(refreshHandler(true, &seeding, func() { t.Error("refresh ran during seeding") }))
+	seeding.Wait()
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestRefreshNeedsSingleArchive(t *testing.T) {
+	resetSeedingProgress(t)
+
+	var seeding sync.WaitGroup
+	w := postRefresh(refreshHandler(false, &seeding, func() { t.Error("refresh ran without an archive") }))
+	seeding.Wait()
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestCompletedSeedReportsInsertedTracks(t *testing.T) {
+	resetSeedingProgress(t)
+	updateSeedingProgress(0, 10, false, "")
+	recordSeedingInserted(4)
+	recordSeedingFailure("broken.gpx")
+	// The other five files were already loaded or duplicates
+	updateSeedingProgress(10, 10, false, "")
+
+	progress := getSeedingProgress()
+	updateSeedingProgress(progress.inserted, 10, true, "")
+
+	progress = getSeedingProgress()
+	if progress.LoadedTracks != 4 {
+		t.Fatalf("LoadedTracks = %d, want 4", progress.LoadedTracks)
+	}
+	if progress.PercentComplete != 100 {
+		t.Fatalf("PercentComplete = %v, want 100", progress.PercentComplete)
+	}
+
+	// A new run starts counting from zero
+	updateSeedingProgress(0, 10, false, "")
+	if inserted := getSeedingProgress().inserted; inserted != 0 {
+		t.Fatalf("inserted = %d after a new run started, want 0", inserted)
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import "math"
+
+// initialBearing returns the initial great-circle bearing from one point to another, in
+// degrees clockwise from north in [0, 360)
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	y := math.Sin(deltaLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(deltaLambda)
+	bearing := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(bearing+360, 360)
+}
+
+// addTrackBearings sets each point's bearing from the point before it. The first point has
+// nothing before it and takes the second point's bearing; a lone point gets none.
+func addTrackBearings(points []TrackCoordinate) {
+	for i := 1; i < len(points); i++ {
+		bearing := initialBearing(points[i-1].Latitude, points[i-1].Longitude, points[i].Latitude, points[i].Longitude)
+		points[i].Bearing = &bearing
+	}
+	if len(points) > 1 {
+		points[0].Bearing = points[1].Bearing
+	}
+}
+
+// AddBearings sets the bearing of every coordinate, for drawing direction arrows
+func (s *TrackService) AddBearings(coordinates map[uint][]TrackCoordinate) {
+	for _, points := range coordinates {
+		addTrackBearings(points)
+	}
+}
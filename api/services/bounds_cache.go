@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mytracks-api/models"
+)
+
+const (
+	// defaultBoundsCacheTTL is how long bounds query results are reused
+	defaultBoundsCacheTTL = 30 * time.Second
+	// boundsCachePrecision rounds cache keys to about 100 m so near-identical map pans share entries
+	boundsCachePrecision = 1000.0
+	// boundsCacheMaxEntries triggers a sweep of expired entries when exceeded
+	boundsCacheMaxEntries = 1000
+	// boundsCacheLogInterval is how many lookups happen between hit/miss log lines
+	boundsCacheLogInterval = 1000
+)
+
+type boundsCacheKey struct {
+	north, south, east, west float64
+	limit                    int
+}
+
+type boundsCacheEntry struct {
+	tracks  []models.GPXTrack
+	expires time.Time
+}
+
+// boundsCache memoizes GetTracksByBounds results for a short TTL. It is cleared whenever
+// tracks are created, changed or deleted, so new tracks show up without waiting it out.
+type boundsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[boundsCacheKey]boundsCacheEntry
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// newBoundsCache returns a cache with the given TTL; a TTL of zero or less disables caching
+func newBoundsCache(ttl time.Duration) *boundsCache {
+	return &boundsCache{
+		ttl:     ttl,
+		entries: make(map[boundsCacheKey]boundsCacheEntry),
+	}
+}
+
+func newBoundsCacheKey(north, south, east, west float64, limit int) boundsCacheKey {
+	round := func(v float64) float64 {
+		return math.Round(v*boundsCachePrecision) / boundsCachePrecision
+	}
+	return boundsCacheKey{round(north), round(south), round(east), round(west), limit}
+}
+
+// get returns the cached tracks for key, counting the lookup as a hit or miss
+func (c *boundsCache) get(key boundsCacheKey) ([]models.GPXTrack, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		c.record(c.hits.Add(1), c.misses.Load())
+		return entry.tracks, true
+	}
+	c.record(c.hits.Load(), c.misses.Add(1))
+	return nil, false
+}
+
+func (c *boundsCache) set(key boundsCacheKey, tracks []models.GPXTrack) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= boundsCacheMaxEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = boundsCacheEntry{tracks: tracks, expires: now.Add(c.ttl)}
+}
+
+// invalidate drops every cached result
+func (c *boundsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[boundsCacheKey]boundsCacheEntry)
+}
+
+// record logs the hit rate every boundsCacheLogInterval lookups
+func (c *boundsCache) record(hits, misses uint64) {
+	if total := hits + misses; total%boundsCacheLogInterval == 0 {
+		fmt.Printf("Bounds cache: %d hits, %d misses (%.1f%% hit rate)\n", hits, misses, float64(hits)/float64(total)*100)
+	}
+}
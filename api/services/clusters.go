@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"mytracks-api/models"
+)
+
+// TrackCluster is a group of tracks whose centroids share a geohash prefix
+type TrackCluster struct {
+	Geohash   string  `json:"geohash"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+}
+
+// clusterPrecision maps a web map zoom level onto a geohash prefix length, so a cluster
+// covers roughly the area of a few map tiles at that zoom
+func clusterPrecision(zoom int) int {
+	switch {
+	case zoom <= 2:
+		return 1
+	case zoom <= 4:
+		return 2
+	case zoom <= 7:
+		return 3
+	case zoom <= 9:
+		return 4
+	case zoom <= 12:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// GetClusters groups the tracks inside the bounds by a geohash prefix whose length
+// depends on zoom, returning the average centroid and track count of each group
+func (s *TrackService) GetClusters(ctx context.Context, north, south, east, west float64, zoom int) ([]TrackCluster, error) {
+	// The prefix expression is inlined rather than bound, so Postgres sees the SELECT and
+	// GROUP BY expressions as identical; precision is always a small int
+	prefix := fmt.Sprintf("LEFT(geohash, %d)", clusterPrecision(zoom))
+
+	query := s.db.WithContext(ctx).Model(&models.GPXTrack{}).
+		Select(prefix + " AS geohash, AVG(centroid_lat) AS latitude, AVG(centroid_lon) AS longitude, COUNT(*) AS count").
+		Where("geohash <> ''")
+
+	// Same cover and precise bounds filtering as GetTracksByBounds
+	if cells := geohashCover(north, south, east, west); len(cells) > 0 {
+		clause, args := geohashCoverClause(cells)
+		query = query.Where(clause, args...)
+	}
+	clause, args := boundsClause(north, south, east, west)
+	query = query.Where(clause, args...)
+
+	clusters := []TrackCluster{}
+	err := query.Group(prefix).Order("count DESC").Scan(&clusters).Error
+	return clusters, err
+}
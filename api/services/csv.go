@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// GetCSVFilename returns the CSV download filename for a track without loading its points
+func (s *TrackService) GetCSVFilename(ctx context.Context, id uint) (string, error) {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Select("id, filename").First(&track, id).Error
+	if err != nil {
+		return "", err
+	}
+
+	if track.Filename == "" {
+		return fmt.Sprintf("track_%d.csv", id), nil
+	}
+	return strings.TrimSuffix(track.Filename, filepath.Ext(track.Filename)) + ".csv", nil
+}
+
+// WriteCSV streams the track points as CSV rows of latitude, longitude, elevation, time and
+// cumulative distance in meters. Missing elevations and times are left empty. Points are
+// read in batches like WriteGPX, so memory use stays flat for huge tracks.
+func (s *TrackService) WriteCSV(ctx context.Context, w io.Writer, id uint) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"latitude", "longitude", "elevation", "time", "cumulative_distance_m"}); err != nil {
+		return err
+	}
+
+	var (
+		batch              []models.TrackPoint
+		prev               *models.TrackPoint
+		cumulativeDistance float64
+	)
+	err := s.db.WithContext(ctx).Where("track_id = ?", id).FindInBatches(&batch, gpxPointBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			point := batch[i]
+			if prev != nil {
+				cumulativeDistance += haversineDistance(prev.Latitude, prev.Longitude, point.Latitude, point.Longitude)
+			}
+			prev = &point
+
+			elevation := ""
+			if point.Elevation != nil {
+				elevation = strconv.FormatFloat(*point.Elevation, 'f', 2, 64)
+			}
+			timestamp := ""
+			if point.Time != nil {
+				timestamp = point.Time.UTC().Format("2006-01-02T15:04:05Z")
+			}
+
+			record := []string{
+				strconv.FormatFloat(point.Latitude, 'f', 6, 64),
+				strconv.FormatFloat(point.Longitude, 'f', 6, 64),
+				elevation,
+				timestamp,
+				strconv.FormatFloat(cumulativeDistance, 'f', 2, 64),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
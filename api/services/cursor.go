@@ -0,0 +1,45 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"mytracks-api/models"
+)
+
+// ErrInvalidCursor is returned for a pagination cursor that can't be decoded or used
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TrackCursor is the position after which the next page of a track listing starts. Pages
+// are keyed on (created_at, id), so tracks inserted while a client pages through the list
+// never shift the pages it hasn't fetched yet.
+type TrackCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
+}
+
+// CursorAfter returns the cursor for the page following track
+func CursorAfter(track models.GPXTrack) TrackCursor {
+	return TrackCursor{CreatedAt: track.CreatedAt, ID: track.ID}
+}
+
+// Encode returns the cursor as an opaque URL-safe token
+func (c TrackCursor) Encode() string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// DecodeTrackCursor parses a token returned by TrackCursor.Encode
+func DecodeTrackCursor(token string) (*TrackCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor TrackCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
@@ -1,16 +1,82 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"mytracks-api/config"
+)
+
+// etagSuffix names the sidecar file holding the ETag a download was served with, so a
+// later refresh can tell whether the remote object changed
+const etagSuffix = ".etag"
+
+// partValidatorSuffix names the sidecar of a .part file holding the ETag or Last-Modified
+// the partial download was served with, sent as If-Range when resuming
+const partValidatorSuffix = ".validator"
+
+// Retry defaults for downloads
+const (
+	defaultDownloadAttempts      = 3
+	defaultDownloadBackoffMillis = 1000
 )
 
+// downloadProgressInterval is how often a running download logs and reports its progress
+const downloadProgressInterval = 2 * time.Second
+
 type DownloadService struct {
-	client *http.Client
+	client       *http.Client
+	maxAttempts  int                           // Total tries per download, including the first
+	retryBackoff time.Duration                 // Wait before the first retry; doubled for each one after
+	progress     func(downloaded, total int64) // Optional; see SetProgressFunc
+}
+
+// SetProgressFunc registers fn to be called every downloadProgressInterval while a file is
+// downloaded, and once when it completes, with the bytes on disk so far and the expected
+// total, which is -1 when the server didn't send a length. Set it before downloading.
+func (s *DownloadService) SetProgressFunc(fn func(downloaded, total int64)) {
+	s.progress = fn
+}
+
+// progressWriter counts the bytes of a download as they're written, logging and
+// reporting them every downloadProgressInterval
+type progressWriter struct {
+	name       string
+	downloaded int64
+	total      int64
+	lastReport time.Time
+	report     func(downloaded, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.downloaded += int64(len(p))
+	if time.Since(w.lastReport) >= downloadProgressInterval {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+// flush logs and reports the current count
+func (w *progressWriter) flush() {
+	w.lastReport = time.Now()
+	if w.total > 0 {
+		fmt.Printf("Downloading %s: %.1f of %.1f MB (%.0f%%)\n", w.name,
+			float64(w.downloaded)/(1<<20), float64(w.total)/(1<<20), float64(w.downloaded)/float64(w.total)*100)
+	} else {
+		fmt.Printf("Downloading %s: %.1f MB\n", w.name, float64(w.downloaded)/(1<<20))
+	}
+	if w.report != nil {
+		w.report(w.downloaded, w.total)
+	}
 }
 
 func NewDownloadService() *DownloadService {
@@ -18,11 +84,28 @@ func NewDownloadService() *DownloadService {
 		client: &http.Client{
 			Timeout: 10 * time.Minute, // Long timeout for large file downloads
 		},
+		maxAttempts:  config.Int("DOWNLOAD_MAX_ATTEMPTS", defaultDownloadAttempts),
+		retryBackoff: time.Duration(config.Int("DOWNLOAD_RETRY_BACKOFF_MS", defaultDownloadBackoffMillis)) * time.Millisecond,
 	}
 }
 
-// DownloadFile downloads a file from the given URL and saves it to the specified path
-func (s *DownloadService) DownloadFile(url, filePath string) error {
+// transientError marks a download failure worth retrying: a network error or a 5xx
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// DownloadFile downloads a file from the given URL and saves it to the specified path.
+// The body is written to a ".part" file that is renamed into place only once complete, and
+// an existing ".part" file is resumed with a Range request when the server supports it.
+// When expectedSHA256 is non-empty, the download is hashed as it streams to disk and the
+// file is removed if the digest doesn't match. The response's ETag is saved next to the file
+// and its Last-Modified time becomes the file's modification time, for RefreshGPXArchive.
+// Network errors and 5xx responses are retried with exponential backoff, resuming from
+// what the failed attempt wrote; 4xx responses and checksum mismatches fail immediately.
+func (s *DownloadService) DownloadFile(url, filePath, expectedSHA256 string) error {
 	// Create the directory if it doesn't exist
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -35,6 +118,35 @@ func (s *DownloadService) DownloadFile(url, filePath string) error {
 		return nil
 	}
 
+	for attempt := 1; ; attempt++ {
+		err := s.downloadOnce(url, filePath, expectedSHA256)
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= s.maxAttempts {
+			return err
+		}
+
+		delay := s.retryBackoff << (attempt - 1)
+		fmt.Printf("Download attempt %d/%d of %s failed: %v; retrying in %s\n", attempt, s.maxAttempts, url, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// downloadOnce makes a single attempt at DownloadFile, returning a transientError for
+// failures worth retrying
+func (s *DownloadService) downloadOnce(url, filePath, expectedSHA256 string) error {
+	// Pick up where a previous attempt left off, but only when we know which version of
+	// the remote object the partial bytes came from
+	partPath := filePath + ".part"
+	validatorPath := partPath + partValidatorSuffix
+	var offset int64
+	var validator string
+	if info, err := os.Stat(partPath); err == nil {
+		if saved, err := os.ReadFile(validatorPath); err == nil && len(saved) > 0 {
+			offset = info.Size()
+			validator = string(saved)
+		}
+	}
+
 	fmt.Printf("Downloading %s to %s...\n", url, filePath)
 
 	// Create HTTP request
@@ -45,40 +157,167 @@ func (s *DownloadService) DownloadFile(url, filePath string) error {
 
 	// Set headers
 	req.Header.Set("User-Agent", "MyTracks-API/1.0")
+	// With If-Range the server sends the whole object instead of the range when it has
+	// changed since the partial file was started, so bytes of two versions never mix
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
 
 	// Make the request
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return &transientError{fmt.Errorf("failed to download file: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
+	// Check response status. A 200 to a ranged request means the server ignored the
+	// Range header, so the partial file is discarded and the download starts over.
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		fmt.Printf("Resuming download of %s from byte %d\n", filePath, offset)
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			fmt.Printf("Remote file changed or ranged requests unsupported, restarting download of %s\n", filePath)
+		}
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file doesn't match the remote object any more; a retry starts fresh
+		os.Remove(partPath)
+		os.Remove(validatorPath)
+		return &transientError{fmt.Errorf("download failed with status: %d %s", resp.StatusCode, resp.Status)}
+	case resp.StatusCode >= 500:
+		return &transientError{fmt.Errorf("download failed with status: %d %s", resp.StatusCode, resp.Status)}
+	default:
 		return fmt.Errorf("download failed with status: %d %s", resp.StatusCode, resp.Status)
 	}
 
-	// Create the file
-	out, err := os.Create(filePath)
+	// Misconfigured buckets can answer 200 with an XML or HTML error document; refuse it
+	// rather than saving it as the file
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && unexpectedDownloadType(mediaType) {
+		return fmt.Errorf("download of %s returned %s instead of the file", url, mediaType)
+	}
+
+	// Open the partial file, appending when resuming
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer out.Close()
 
-	// Copy the response body to file
-	bytesWritten, err := io.Copy(out, resp.Body)
+	// A fresh download records which version it is, for resuming it later
+	if offset == 0 {
+		if validator := resumeValidator(resp.Header); validator != "" {
+			if err := os.WriteFile(validatorPath, []byte(validator), 0644); err != nil {
+				fmt.Printf("Warning: failed to save resume validator for %s: %v\n", filePath, err)
+			}
+		} else {
+			os.Remove(validatorPath)
+		}
+	}
+
+	// Seed the hash with the bytes already on disk
+	hash := sha256.New()
+	if expectedSHA256 != "" && offset > 0 {
+		if err := hashFile(hash, partPath); err != nil {
+			return fmt.Errorf("failed to read partial file: %w", err)
+		}
+	}
+
+	// Copy the response body to file, hashing it and reporting progress along the way.
+	// The partial file is kept on error so the next attempt can resume.
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := &progressWriter{name: filepath.Base(filePath), downloaded: offset, total: total, lastReport: time.Now(), report: s.progress}
+	bytesWritten, err := io.Copy(io.MultiWriter(out, hash, progress), resp.Body)
 	if err != nil {
-		// Clean up partial file on error
-		os.Remove(filePath)
+		return &transientError{fmt.Errorf("failed to write file: %w", err)}
+	}
+	progress.flush()
+	if err := out.Close(); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
-	fmt.Printf("Successfully downloaded %d bytes to %s\n", bytesWritten, filePath)
+	// Verify the final size when the server told us how much to expect. The partial file
+	// can't be trusted after a mismatch, so the retry starts over.
+	totalSize := offset + bytesWritten
+	if resp.ContentLength >= 0 && bytesWritten != resp.ContentLength {
+		os.Remove(partPath)
+		os.Remove(validatorPath)
+		return &transientError{fmt.Errorf("incomplete download of %s: got %d of %d bytes", filePath, bytesWritten, resp.ContentLength)}
+	}
+
+	// Verify integrity when a checksum was provided
+	if expectedSHA256 != "" {
+		actualSHA256 := hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(actualSHA256, expectedSHA256) {
+			os.Remove(partPath)
+			os.Remove(validatorPath)
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filePath, expectedSHA256, actualSHA256)
+		}
+		fmt.Printf("Verified SHA-256 checksum of %s\n", filePath)
+	}
+
+	if err := os.Rename(partPath, filePath); err != nil {
+		return fmt.Errorf("failed to move completed download into place: %w", err)
+	}
+	os.Remove(validatorPath)
+
+	// Remember which version of the remote object this is
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := os.WriteFile(filePath+etagSuffix, []byte(etag), 0644); err != nil {
+			fmt.Printf("Warning: failed to save ETag for %s: %v\n", filePath, err)
+		}
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(filePath, lastModified, lastModified)
+	}
+
+	fmt.Printf("Successfully downloaded %d bytes to %s\n", totalSize, filePath)
 	return nil
 }
 
-// EnsureGPXArchive ensures the GPX archive exists, downloading it from S3 if necessary
-func (s *DownloadService) EnsureGPXArchive(archivePath, s3URL string) error {
+// resumeValidator returns the value to send as If-Range when resuming a download of this
+// response: its ETag when strong, since If-Range can't use weak ones, or its Last-Modified
+// date. It returns "" when neither is usable, and such downloads start over instead.
+func resumeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// unexpectedDownloadType reports whether a response media type is an error page or error
+// document rather than a downloadable file
+func unexpectedDownloadType(mediaType string) bool {
+	switch strings.ToLower(mediaType) {
+	case "text/html", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+// hashFile feeds the contents of the file at path into hash
+func hashFile(hash io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(hash, f)
+	return err
+}
+
+// EnsureGPXArchive ensures the GPX archive exists, downloading it from S3 if necessary.
+// An empty expectedSHA256 skips checksum verification.
+func (s *DownloadService) EnsureGPXArchive(archivePath, s3URL, expectedSHA256 string) error {
 	// Check if the archive already exists
 	if _, err := os.Stat(archivePath); err == nil {
 		fmt.Printf("GPX archive already exists at %s\n", archivePath)
@@ -87,5 +326,204 @@ func (s *DownloadService) EnsureGPXArchive(archivePath, s3URL string) error {
 
 	// Download from S3
 	fmt.Printf("GPX archive not found locally, downloading from S3...\n")
-	return s.DownloadFile(s3URL, archivePath)
+	if err := s.DownloadFile(s3URL, archivePath, expectedSHA256); err != nil {
+		return err
+	}
+
+	// Don't keep a bad download around, or every restart would skip fetching a good one
+	if err := ValidateGPXArchive(archivePath); err != nil {
+		os.Remove(archivePath)
+		os.Remove(archivePath + etagSuffix)
+		return err
+	}
+	return nil
+}
+
+// ValidateGPXArchive checks that the file at path starts with the gzip magic bytes, so an
+// error page saved in place of the archive is reported clearly instead of surfacing as a
+// gzip error deep in the loader
+func ValidateGPXArchive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 32)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	head = head[:n]
+
+	if len(head) < 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return fmt.Errorf("%s is not a gzip archive (starts with %q); it may be an error page saved in place of the archive", path, head)
+	}
+	return nil
+}
+
+// RefreshGPXArchive re-downloads the archive when the object at s3URL has changed since the
+// local copy was fetched, judged by its ETag or, failing that, its Last-Modified time. The
+// new archive is downloaded alongside the old one and only replaces it once complete. It
+// returns true when a new archive was put in place.
+func (s *DownloadService) RefreshGPXArchive(archivePath, s3URL, expectedSHA256 string) (bool, error) {
+	req, err := http.NewRequest("HEAD", s3URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "MyTracks-API/1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to check archive: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("archive check failed with status: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	if !remoteIsNewer(archivePath, resp.Header) {
+		fmt.Printf("GPX archive at %s is up to date\n", archivePath)
+		return false, nil
+	}
+
+	// A leftover partial download may belong to an older version, so start clean
+	newPath := archivePath + ".new"
+	os.Remove(newPath)
+	os.Remove(newPath + ".part")
+	if err := s.DownloadFile(s3URL, newPath, expectedSHA256); err != nil {
+		return false, err
+	}
+	if err := ValidateGPXArchive(newPath); err != nil {
+		os.Remove(newPath)
+		os.Remove(newPath + etagSuffix)
+		return false, err
+	}
+
+	if err := os.Rename(newPath, archivePath); err != nil {
+		return false, fmt.Errorf("failed to replace archive: %w", err)
+	}
+	os.Remove(archivePath + etagSuffix)
+	if _, err := os.Stat(newPath + etagSuffix); err == nil {
+		os.Rename(newPath+etagSuffix, archivePath+etagSuffix)
+	}
+
+	fmt.Printf("GPX archive at %s refreshed\n", archivePath)
+	return true, nil
+}
+
+// remoteIsNewer reports whether the remote object described by header differs from the
+// local archive. An ETag is compared against the one saved at download time; without one,
+// the archive counts as stale when Last-Modified is later than its modification time.
+func remoteIsNewer(archivePath string, header http.Header) bool {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return true
+	}
+
+	if etag := header.Get("ETag"); etag != "" {
+		if stored, err := os.ReadFile(archivePath + etagSuffix); err == nil {
+			return strings.TrimSpace(string(stored)) != etag
+		}
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		return lastModified.After(info.ModTime())
+	}
+
+	// Nothing to compare against, so keep the archive we have
+	return false
+}
+
+// FetchCachedArchive keeps the archive at s3URL in cacheDir and returns the path of its
+// current version, and whether that version was just downloaded. Each version is stored
+// under a name derived from the URL and the ETag it was served with, so a changed object
+// is downloaded next to the old one instead of replacing it, and an unchanged one is
+// reused across restarts. The ETag last seen for the URL is sent as If-None-Match. When the
+// check fails, for example because S3 can't be reached, the cached copy is used if there
+// is one. Objects served without an ETag are cached once and never re-downloaded.
+func (s *DownloadService) FetchCachedArchive(cacheDir, s3URL, expectedSHA256 string) (string, bool, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", false, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	urlKey := shortHash(s3URL)
+	indexPath := filepath.Join(cacheDir, urlKey+etagSuffix)
+
+	// The version fetched last time, if it's still on disk
+	cached, cachedETag := "", ""
+	if stored, err := os.ReadFile(indexPath); err == nil {
+		cachedETag = strings.TrimSpace(string(stored))
+		path := cachedArchivePath(cacheDir, urlKey, cachedETag)
+		if _, err := os.Stat(path); err == nil {
+			cached = path
+		}
+	}
+
+	req, err := http.NewRequest("HEAD", s3URL, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "MyTracks-API/1.0")
+	if cached != "" && cachedETag != "" {
+		req.Header.Set("If-None-Match", cachedETag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+			err = fmt.Errorf("archive check failed with status: %d %s", resp.StatusCode, resp.Status)
+		}
+	}
+	if err != nil {
+		if cached != "" {
+			fmt.Printf("Warning: could not check %s (%v), using cached archive %s\n", s3URL, err, cached)
+			return cached, false, nil
+		}
+		return "", false, err
+	}
+
+	// Unchanged, whether or not the server honored If-None-Match
+	etag := resp.Header.Get("ETag")
+	if cached != "" && (resp.StatusCode == http.StatusNotModified || etag == cachedETag) {
+		fmt.Printf("Cached GPX archive %s is up to date\n", cached)
+		return cached, false, nil
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return "", false, fmt.Errorf("archive check returned 304 but the cached archive is missing")
+	}
+
+	// DownloadFile skips versions that are already cached, such as when the URL's object
+	// was changed back
+	target := cachedArchivePath(cacheDir, urlKey, etag)
+	_, statErr := os.Stat(target)
+	downloaded := statErr != nil
+	if err := s.DownloadFile(s3URL, target, expectedSHA256); err != nil {
+		return "", false, err
+	}
+	if err := ValidateGPXArchive(target); err != nil {
+		os.Remove(target)
+		os.Remove(target + etagSuffix)
+		return "", false, err
+	}
+
+	if err := os.WriteFile(indexPath, []byte(etag), 0644); err != nil {
+		fmt.Printf("Warning: failed to save cache index for %s: %v\n", s3URL, err)
+	}
+	return target, downloaded, nil
+}
+
+// cachedArchivePath names the cached copy of one version of the archive at a URL
+func cachedArchivePath(cacheDir, urlKey, etag string) string {
+	if etag == "" {
+		return filepath.Join(cacheDir, urlKey+".tar.gz")
+	}
+	return filepath.Join(cacheDir, urlKey+"-"+shortHash(etag)+".tar.gz")
+}
+
+// shortHash returns a short hex digest of value, for use in file names
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
 }
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// archiveServer serves content with the given ETag, supporting Range and If-Range, and
+// records the Range header of each request
+func archiveServer(t *testing.T, content []byte, etag string, ranges *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "archive.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writePart leaves a partial download of filePath behind, as an interrupted attempt would
+func writePart(t *testing.T, filePath string, data []byte, validator string) {
+	t.Helper()
+	if err := os.WriteFile(filePath+".part", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if validator != "" {
+		if err := os.WriteFile(filePath+".part"+partValidatorSuffix, []byte(validator), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDownloadResumesSameVersion(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	var ranges []string
+	server := archiveServer(t, content, `"v1"`, &ranges)
+
+	filePath := filepath.Join(t.TempDir(), "archive.tar.gz")
+	writePart(t, filePath, content[:8], `"v1"`)
+
+	s := &DownloadService{client: server.Client(), maxAttempts: 1}
+	if err := s.DownloadFile(server.URL, filePath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(filePath)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file = %q, want %q", got, content)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=8-" {
+		t.Fatalf("Range headers = %q, want one resume from byte 8", ranges)
+	}
+	if _, err := os.Stat(filePath + ".part" + partValidatorSuffix); !os.IsNotExist(err) {
+		t.Fatal("validator left behind after a completed download")
+	}
+}
+
+func TestDownloadRestartsWhenRemoteChanged(t *testing.T) {
+	content := []byte("the new version of the archive")
+	var ranges []string
+	server := archiveServer(t, content, `"v2"`, &ranges)
+
+	filePath := filepath.Join(t.TempDir(), "archive.tar.gz")
+	writePart(t, filePath, []byte("the old ver"), `"v1"`)
+
+	s := &DownloadService{client: server.Client(), maxAttempts: 1}
+	if err := s.DownloadFile(server.URL, filePath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// If-Range didn't match, so the server sent the whole new object
+	got, _ := os.ReadFile(filePath)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file = %q, want %q", got, content)
+	}
+}
+
+func TestDownloadWithoutValidatorStartsOver(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	var ranges []string
+	server := archiveServer(t, content, `"v1"`, &ranges)
+
+	filePath := filepath.Join(t.TempDir(), "archive.tar.gz")
+	writePart(t, filePath, []byte("unknown!"), "")
+
+	s := &DownloadService{client: server.Client(), maxAttempts: 1}
+	if err := s.DownloadFile(server.URL, filePath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("Range headers = %q, want a plain request", ranges)
+	}
+	got, _ := os.ReadFile(filePath)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file = %q, want %q", got, content)
+	}
+}
+
+func TestResumeValidator(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"strong ETag", http.Header{"Etag": {`"abc"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, `"abc"`},
+		{"weak ETag", http.Header{"Etag": {`W/"abc"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, "Mon, 02 Jan 2006 15:04:05 GMT"},
+		{"neither", http.Header{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resumeValidator(tt.header); got != tt.want {
+				t.Fatalf("resumeValidator = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
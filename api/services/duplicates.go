@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"math"
+
+	"mytracks-api/models"
+)
+
+const (
+	// duplicateGeohashPrecision is the geohash prefix length candidates must share (~5 km cells)
+	duplicateGeohashPrecision = 5
+	// duplicateEndpointTolerance is how far apart, in meters, matching start or end points may be
+	duplicateEndpointTolerance = 100.0
+	// duplicateDistanceTolerance is the allowed relative difference in total distance
+	duplicateDistanceTolerance = 0.05
+)
+
+// trackEndpoints holds the first and last point of a track
+type trackEndpoints struct {
+	Start TrackCoordinate
+	End   TrackCoordinate
+}
+
+// GetDuplicates returns the stored tracks that are likely the same route as the given track
+func (s *TrackService) GetDuplicates(ctx context.Context, id uint) ([]models.GPXTrack, error) {
+	var track models.GPXTrack
+	if err := s.db.WithContext(ctx).First(&track, id).Error; err != nil {
+		return nil, err
+	}
+	return s.FindDuplicates(ctx, &track)
+}
+
+// FindDuplicate returns the first stored track that looks like the same route as track,
+// or nil when there is none. track doesn't need to be saved yet.
+func (s *TrackService) FindDuplicate(ctx context.Context, track *models.GPXTrack) (*models.GPXTrack, error) {
+	duplicates, err := s.FindDuplicates(ctx, track)
+	if err != nil || len(duplicates) == 0 {
+		return nil, err
+	}
+	return &duplicates[0], nil
+}
+
+// FindDuplicates compares track against stored tracks sharing its geohash prefix. A
+// candidate is a duplicate when its total distance is within duplicateDistanceTolerance
+// and both its start and end points lie within duplicateEndpointTolerance of the track's.
+// The endpoints come from track.TrackPoints when loaded, otherwise from the database.
+func (s *TrackService) FindDuplicates(ctx context.Context, track *models.GPXTrack) ([]models.GPXTrack, error) {
+	if len(track.Geohash) < duplicateGeohashPrecision {
+		return nil, nil
+	}
+
+	var endpoints trackEndpoints
+	if n := len(track.TrackPoints); n > 0 {
+		first, last := track.TrackPoints[0], track.TrackPoints[n-1]
+		endpoints.Start = TrackCoordinate{Latitude: first.Latitude, Longitude: first.Longitude}
+		endpoints.End = TrackCoordinate{Latitude: last.Latitude, Longitude: last.Longitude}
+	} else {
+		stored, err := s.getTrackEndpoints(ctx, []uint{track.ID})
+		if err != nil {
+			return nil, err
+		}
+		found, ok := stored[track.ID]
+		if !ok {
+			return nil, nil
+		}
+		endpoints = found
+	}
+
+	// Candidates in the same area with a similar distance
+	var candidates []models.GPXTrack
+	query := s.db.WithContext(ctx).Model(&models.GPXTrack{}).
+		Where("geohash LIKE ?", track.Geohash[:duplicateGeohashPrecision]+"%").
+		Where("distance BETWEEN ? AND ?", track.Distance*(1-duplicateDistanceTolerance), track.Distance*(1+duplicateDistanceTolerance))
+	if track.ID != 0 {
+		query = query.Where("id <> ?", track.ID)
+	}
+	if err := query.Order("id").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.ID
+	}
+	candidateEndpoints, err := s.getTrackEndpoints(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates []models.GPXTrack
+	for _, candidate := range candidates {
+		other, ok := candidateEndpoints[candidate.ID]
+		if !ok {
+			continue
+		}
+		if coordinatesWithin(endpoints.Start, other.Start, duplicateEndpointTolerance) &&
+			coordinatesWithin(endpoints.End, other.End, duplicateEndpointTolerance) {
+			duplicates = append(duplicates, candidate)
+		}
+	}
+
+	return duplicates, nil
+}
+
+// getTrackEndpoints loads the first and last stored point of each track
+func (s *TrackService) getTrackEndpoints(ctx context.Context, trackIDs []uint) (map[uint]trackEndpoints, error) {
+	type endpointRow struct {
+		TrackID   uint
+		Latitude  float64
+		Longitude float64
+	}
+
+	var starts, ends []endpointRow
+	err := s.db.WithContext(ctx).Raw(`SELECT DISTINCT ON (track_id) track_id, latitude, longitude
+		FROM track_points WHERE track_id IN ? ORDER BY track_id, id ASC`, trackIDs).Scan(&starts).Error
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.WithContext(ctx).Raw(`SELECT DISTINCT ON (track_id) track_id, latitude, longitude
+		FROM track_points WHERE track_id IN ? ORDER BY track_id, id DESC`, trackIDs).Scan(&ends).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint]trackEndpoints, len(starts))
+	for _, row := range starts {
+		result[row.TrackID] = trackEndpoints{Start: TrackCoordinate{Latitude: row.Latitude, Longitude: row.Longitude}}
+	}
+	for _, row := range ends {
+		if endpoints, ok := result[row.TrackID]; ok {
+			endpoints.End = TrackCoordinate{Latitude: row.Latitude, Longitude: row.Longitude}
+			result[row.TrackID] = endpoints
+		}
+	}
+
+	return result, nil
+}
+
+// coordinatesWithin reports whether two coordinates are at most toleranceMeters apart
+func coordinatesWithin(a, b TrackCoordinate, toleranceMeters float64) bool {
+	distance := haversineDistance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	return !math.IsNaN(distance) && distance <= toleranceMeters
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrTooFewPoints is returned when a track is created from fewer than two points
+var ErrTooFewPoints = errors.New("a track needs at least two points")
+
+// ErrInvalidCoordinate is returned when a submitted point is outside the valid lat/lon range
+var ErrInvalidCoordinate = errors.New("invalid coordinate")
+
+// CreateTrackFromPoints stores a track recorded as raw points rather than a GPX file,
+// computing its stats the same way as for parsed files. The track gets a generated
+// filename; an empty name falls back to it.
+func (s *TrackService) CreateTrackFromPoints(ctx context.Context, name string, points []models.TrackPoint) (*models.GPXTrack, error) {
+	if len(points) < 2 {
+		return nil, ErrTooFewPoints
+	}
+
+	track := &models.GPXTrack{
+		Filename:         fmt.Sprintf("points_%d.gpx", time.Now().UnixNano()),
+		Name:             name,
+		SourceTrackCount: 1,
+		TrackPoints:      make([]models.TrackPoint, len(points)),
+	}
+	if track.Name == "" {
+		track.Name = track.Filename[:len(track.Filename)-len(".gpx")]
+	}
+
+	// Fresh copies so client-supplied IDs are ignored
+	for i, point := range points {
+		if !validCoordinate(point.Latitude, point.Longitude) {
+			return nil, fmt.Errorf("%w at point %d: %f, %f", ErrInvalidCoordinate, i, point.Latitude, point.Longitude)
+		}
+		track.TrackPoints[i] = models.TrackPoint{
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Elevation: point.Elevation,
+			Time:      point.Time,
+		}
+	}
+
+	s.gpxService.computeTrackStats(track)
+
+	defer s.boundsCache.invalidate()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.createTrackWithPoints(tx, track)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return track, nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"mytracks-api/models"
+)
+
+// RegionProvider resolves a coarse location label, such as a country or state, for a
+// coordinate. An empty label means the provider doesn't know the place.
+type RegionProvider interface {
+	Region(ctx context.Context, lat, lon float64) (string, error)
+}
+
+// noopRegionProvider resolves nothing, so the API works offline without a geocoder
+type noopRegionProvider struct{}
+
+func (noopRegionProvider) Region(ctx context.Context, lat, lon float64) (string, error) {
+	return "", nil
+}
+
+// GeocodeService labels tracks with the region their centroid falls in
+type GeocodeService struct {
+	provider RegionProvider
+}
+
+// NewGeocodeService wraps provider; a nil provider leaves regions unset
+func NewGeocodeService(provider RegionProvider) *GeocodeService {
+	if provider == nil {
+		provider = noopRegionProvider{}
+	}
+	return &GeocodeService{provider: provider}
+}
+
+// Enabled reports whether a real provider is configured
+func (s *GeocodeService) Enabled() bool {
+	_, noop := s.provider.(noopRegionProvider)
+	return !noop
+}
+
+// RegionFor returns the region label for a track's centroid
+func (s *GeocodeService) RegionFor(ctx context.Context, track models.GPXTrack) (string, error) {
+	lat, lon := centroidOf(track)
+	return s.provider.Region(ctx, lat, lon)
+}
+
+// PopulateMissingRegions labels tracks that have no region yet. It makes one provider call
+// per track, so it belongs in the background backfill rather than the request path.
+func (s *TrackService) PopulateMissingRegions(geocoder *GeocodeService) {
+	log := fmt.Printf // Use fmt.Printf for logging in this goroutine
+
+	if !geocoder.Enabled() {
+		log("No region provider configured, skipping region population\n")
+		return
+	}
+
+	var tracks []models.GPXTrack
+	err := s.db.Select("id, north, south, east, west, centroid_lat, centroid_lon").Where("region = '' OR region IS NULL").Find(&tracks).Error
+	if err != nil {
+		log("Error finding tracks with missing region: %v\n", err)
+		return
+	}
+
+	log("Found %d tracks missing regions, resolving...\n", len(tracks))
+
+	updated := 0
+	for _, track := range tracks {
+		region, err := geocoder.RegionFor(context.Background(), track)
+		if err != nil {
+			log("Error resolving region for track %d: %v\n", track.ID, err)
+			continue
+		}
+		if region == "" {
+			continue
+		}
+
+		if err := s.db.Model(&track).Update("region", region).Error; err != nil {
+			log("Error updating region for track %d: %v\n", track.ID, err)
+			continue
+		}
+		updated++
+	}
+
+	log("Completed region population: updated %d tracks\n", updated)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// maxCoverCells caps how many geohash cells a bounds query may expand into before
+// we give up on the geohash prefilter and rely on the precise bounds check alone
+const maxCoverCells = 16
+
+// maxCoverPrecision is the finest geohash precision used for covering a bounding box
+const maxCoverPrecision = 6
+
+// geohashCover returns the set of geohash cells covering the bounding box, expanded
+// with each cell's neighbors so tracks whose centroid sits just across a cell edge
+// are still matched. Boxes crossing the antimeridian (west > east) are covered as two
+// longitude ranges. Returns nil when the box is too large for a useful prefilter.
+func geohashCover(north, south, east, west float64) []string {
+	north, south = clampLatitude(north), clampLatitude(south)
+	if south > north {
+		north, south = south, north
+	}
+
+	lonRanges := [][2]float64{{clampLongitude(west), clampLongitude(east)}}
+	if west > east {
+		lonRanges = [][2]float64{
+			{clampLongitude(west), clampLongitude(180)},
+			{clampLongitude(-180), clampLongitude(east)},
+		}
+	}
+
+	for precision := uint(maxCoverPrecision); precision >= 1; precision-- {
+		// All cells at a given precision have the same size
+		cell := geohash.BoundingBox(geohash.EncodeWithPrecision(0, 0, precision))
+		cellHeight := cell.MaxLat - cell.MinLat
+		cellWidth := cell.MaxLng - cell.MinLng
+
+		count := 0
+		rows := int(math.Ceil((north-south)/cellHeight)) + 1
+		for _, lonRange := range lonRanges {
+			count += rows * (int(math.Ceil((lonRange[1]-lonRange[0])/cellWidth)) + 1)
+		}
+		if count > maxCoverCells {
+			continue
+		}
+
+		cells := make(map[string]bool)
+		for _, lonRange := range lonRanges {
+			for lat := south; ; lat += cellHeight {
+				sampleLat := math.Min(lat, north)
+				for lon := lonRange[0]; ; lon += cellWidth {
+					sampleLon := math.Min(lon, lonRange[1])
+					hash := geohash.EncodeWithPrecision(sampleLat, sampleLon, precision)
+					cells[hash] = true
+					for _, neighbor := range geohash.Neighbors(hash) {
+						cells[neighbor] = true
+					}
+					if sampleLon >= lonRange[1] {
+						break
+					}
+				}
+				if sampleLat >= north {
+					break
+				}
+			}
+		}
+
+		result := make([]string, 0, len(cells))
+		for hash := range cells {
+			result = append(result, hash)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	return nil
+}
+
+// geohashCoverClause builds a parenthesized "geohash LIKE ? OR ..." condition for the cells
+func geohashCoverClause(cells []string) (string, []interface{}) {
+	conditions := make([]string, len(cells))
+	args := make([]interface{}, len(cells))
+	for i, cell := range cells {
+		conditions[i] = "geohash LIKE ?"
+		args[i] = cell + "%"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// clampLatitude keeps latitudes strictly inside the range geohash can encode
+func clampLatitude(lat float64) float64 {
+	return math.Max(-89.999999, math.Min(89.999999, lat))
+}
+
+// clampLongitude keeps longitudes strictly inside the range geohash can encode
+func clampLongitude(lon float64) float64 {
+	return math.Max(-180, math.Min(179.999999, lon))
+}
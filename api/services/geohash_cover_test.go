@@ -0,0 +1,69 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// covered reports whether a centroid at lat/lon would match one of the cells' LIKE prefixes
+func covered(cells []string, lat, lon float64) bool {
+	hash := geohash.Encode(lat, lon)
+	for _, cell := range cells {
+		if strings.HasPrefix(hash, cell) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGeohashCover(t *testing.T) {
+	tests := []struct {
+		name                     string
+		north, south, east, west float64
+		inside                   [][2]float64
+	}{
+		// (0,0) is where the first-character cells 7, k, s and e meet, so a single common
+		// prefix of the corners would be empty
+		{"first character boundary", 0.01, -0.01, 0.01, -0.01, [][2]float64{
+			{0.005, 0.005}, {0.005, -0.005}, {-0.005, 0.005}, {-0.005, -0.005},
+		}},
+		{"first character boundary at 45N", 45.2, 44.8, -89.8, -90.2, [][2]float64{
+			{45.1, -89.9}, {44.9, -90.1},
+		}},
+		{"antimeridian", -16, -18, -179.5, 179.5, [][2]float64{
+			{-17, 179.8}, {-17, -179.8}, {-16.1, 179.99}, {-17.9, -179.6},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells := geohashCover(tt.north, tt.south, tt.east, tt.west)
+			if len(cells) == 0 || len(cells) > maxCoverCells*9 {
+				t.Fatalf("got %d cells, want a usable cover", len(cells))
+			}
+			for _, point := range tt.inside {
+				if !covered(cells, point[0], point[1]) {
+					t.Errorf("(%v, %v) isn't covered by %v", point[0], point[1], cells)
+				}
+			}
+		})
+	}
+}
+
+func TestGeohashCoverTooLarge(t *testing.T) {
+	if cells := geohashCover(80, -80, 170, -170); cells != nil {
+		t.Fatalf("got %d cells, want nil for a box too large to prefilter", len(cells))
+	}
+}
+
+func TestGeohashCoverClause(t *testing.T) {
+	clause, args := geohashCoverClause([]string{"9q", "rz"})
+	if clause != "(geohash LIKE ? OR geohash LIKE ?)" {
+		t.Errorf("clause = %q", clause)
+	}
+	if want := []interface{}{"9q%", "rz%"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"mytracks-api/models"
+)
+
+// GeoJSON structures for exporting a track as a FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// GetGeoJSON returns the track as a GeoJSON FeatureCollection along with a download filename
+func (s *TrackService) GetGeoJSON(ctx context.Context, id uint) ([]byte, string, error) {
+	// Get track with all points
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints").First(&track, id).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(s.generateGeoJSON(track))
+	if err != nil {
+		return nil, "", err
+	}
+
+	filename := fmt.Sprintf("track_%d.geojson", id)
+	if track.Filename != "" {
+		filename = strings.TrimSuffix(track.Filename, filepath.Ext(track.Filename)) + ".geojson"
+	}
+
+	return data, filename, nil
+}
+
+func (s *TrackService) generateGeoJSON(track models.GPXTrack) GeoJSONFeatureCollection {
+	// Coordinates are [lon, lat, ele] per the GeoJSON spec, omitting elevation when unknown
+	coordinates := make([][]float64, 0, len(track.TrackPoints))
+	for _, point := range track.TrackPoints {
+		coordinate := []float64{point.Longitude, point.Latitude}
+		if point.Elevation != nil {
+			coordinate = append(coordinate, *point.Elevation)
+		}
+		coordinates = append(coordinates, coordinate)
+	}
+
+	return GeoJSONFeatureCollection{
+		Type: "FeatureCollection",
+		Features: []GeoJSONFeature{
+			{
+				Type: "Feature",
+				Geometry: GeoJSONGeometry{
+					Type:        "LineString",
+					Coordinates: coordinates,
+				},
+				Properties: map[string]interface{}{
+					"name":           track.Name,
+					"distance":       track.Distance,
+					"duration":       track.Duration,
+					"elevation_gain": track.ElevationGain,
+					"elevation_loss": track.ElevationLoss,
+					"max_elevation":  track.MaxElevation,
+					"min_elevation":  track.MinElevation,
+				},
+			},
+		},
+	}
+}
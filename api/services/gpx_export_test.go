@@ -0,0 +1,77 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"mytracks-api/models"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+func TestGenerateGPXEscapesText(t *testing.T) {
+	name := `Tom & Jerry's <Hike>`
+	description := `Up the "steep" bit & back down </desc>`
+	author := `A & B <team>`
+	creator := `Tracker "Pro" & co`
+	symbol := `Flag, <Red>`
+	track := models.GPXTrack{
+		Name:        name,
+		Description: &description,
+		Author:      &author,
+		Creator:     &creator,
+		Waypoints:   []models.Waypoint{{Latitude: 47.1, Longitude: 8.1, Name: name, Description: &description, Symbol: &symbol}},
+		TrackPoints: []models.TrackPoint{{Latitude: 47.1, Longitude: 8.1}, {Latitude: 47.2, Longitude: 8.2}},
+	}
+
+	s := &TrackService{}
+	for _, version := range []string{GPXVersion10, GPXVersion11} {
+		t.Run(version, func(t *testing.T) {
+			parsed, err := gpx.ParseBytes([]byte(s.generateGPX(track, version, DefaultGPXPrecision)))
+			if err != nil {
+				t.Fatalf("generated GPX doesn't re-parse: %v", err)
+			}
+
+			checks := []struct{ field, got, want string }{
+				{"name", parsed.Name, name},
+				{"desc", parsed.Description, description},
+				{"author", parsed.AuthorName, author},
+				{"creator", parsed.Creator, creator},
+				{"track name", parsed.Tracks[0].Name, name},
+				{"waypoint name", parsed.Waypoints[0].Name, name},
+				{"waypoint desc", parsed.Waypoints[0].Description, description},
+				{"waypoint sym", parsed.Waypoints[0].Symbol, symbol},
+			}
+			for _, check := range checks {
+				if check.got != check.want {
+					t.Errorf("%s = %q, want %q", check.field, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateGPXVersion(t *testing.T) {
+	track := models.GPXTrack{Name: "Ride", TrackPoints: []models.TrackPoint{{Latitude: 47.1, Longitude: 8.1}}}
+	tests := []struct{ version, xmlns string }{
+		{GPXVersion10, "http://www.topografix.com/GPX/1/0"},
+		{GPXVersion11, "http://www.topografix.com/GPX/1/1"},
+	}
+
+	s := &TrackService{}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			output := s.generateGPX(track, tt.version, DefaultGPXPrecision)
+			if !strings.Contains(output, `version="`+tt.version+`"`) || !strings.Contains(output, `xmlns="`+tt.xmlns+`"`) {
+				t.Fatalf("output doesn't declare GPX %s: %s", tt.version, output)
+			}
+			parsed, err := gpx.ParseBytes([]byte(output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Version != tt.version || parsed.Tracks[0].Name != "Ride" {
+				t.Fatalf("re-parsed version %q, track name %q", parsed.Version, parsed.Tracks[0].Name)
+			}
+		})
+	}
+}
@@ -1,27 +1,78 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"mytracks-api/config"
 	"mytracks-api/models"
 
-	"github.com/mmcloughlin/geohash"
 	"github.com/tkrajina/gpxgo/gpx"
 )
 
-type GPXService struct{}
+// ErrNoTrackPoints is returned for GPX files whose tracks have no usable points
+var ErrNoTrackPoints = errors.New("GPX file has no track points")
 
+// movingSpeedThreshold is the speed (m/s) above which an interval counts as moving time
+const movingSpeedThreshold = 0.5
+
+// defaultSmoothingWindow is the number of points averaged when smoothing elevations
+const defaultSmoothingWindow = 5
+
+// defaultElevationGainThreshold is the elevation change in meters needed before a climb or
+// descent counts toward gain and loss
+const defaultElevationGainThreshold = 3.0
+
+// Defaults of the GPS drift filter: sub-meter jitter, and jumps faster than 180 km/h
+const (
+	defaultMinSegmentDistance = 1.0
+	defaultMaxSegmentSpeed    = 50.0
+)
+
+type GPXService struct {
+	statsOptions   StatsOptions
+	maxTrackPoints int  // Most points kept per track; 0 means no limit
+	truncatePoints bool // Keep the first maxTrackPoints points instead of rejecting the file
+}
+
+// NewGPXService reads its options from the environment. DISTANCE_ALGORITHM=vincenty
+// measures distances on the ellipsoid rather than the default haversine, and
+// DISTANCE_MODE=3d makes Distance include elevation changes. MIN_SEGMENT_DISTANCE_METERS
+// and MAX_SEGMENT_SPEED set the GPS drift filter (see StatsOptions). MAX_TRACK_POINTS
+// bounds the points copied into a track record, and so the memory its insert and later
+// responses need; the file itself has already been parsed by then, so it doesn't limit
+// gpxgo's own usage. MAX_TRACK_POINTS_MODE=truncate keeps the first points instead of
+// rejecting the file.
 func NewGPXService() *GPXService {
-	return &GPXService{}
+	algorithm := strings.ToLower(os.Getenv("DISTANCE_ALGORITHM"))
+	if algorithm != "" && algorithm != "haversine" && algorithm != "vincenty" {
+		fmt.Printf("Unknown DISTANCE_ALGORITHM %q, using haversine\n", algorithm)
+	}
+
+	return &GPXService{
+		statsOptions: StatsOptions{
+			SmoothingWindow:    config.Int("ELEVATION_SMOOTHING_WINDOW", defaultSmoothingWindow),
+			GainThreshold:      config.Float("ELEVATION_GAIN_THRESHOLD", defaultElevationGainThreshold),
+			Vincenty:           algorithm == "vincenty",
+			Distance3D:         strings.EqualFold(os.Getenv("DISTANCE_MODE"), "3d"),
+			MinSegmentDistance: config.Float("MIN_SEGMENT_DISTANCE_METERS", defaultMinSegmentDistance),
+			MaxSegmentSpeed:    config.Float("MAX_SEGMENT_SPEED", defaultMaxSegmentSpeed),
+		},
+		maxTrackPoints: config.Int("MAX_TRACK_POINTS", 0),
+		truncatePoints: strings.EqualFold(os.Getenv("MAX_TRACK_POINTS_MODE"), "truncate"),
+	}
 }
 
-func (s *GPXService) ParseGPXFile(filename string) (*models.GPXTrack, error) {
+func (s *GPXService) ParseGPXFile(filename string) (track *models.GPXTrack, err error) {
+	defer recoverParsePanic(&err)
+
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
@@ -36,8 +87,19 @@ func (s *GPXService) ParseGPXFile(filename string) (*models.GPXTrack, error) {
 	return s.processGPXData(gpxData, filepath.Base(filename))
 }
 
-func (s *GPXService) ParseGPXData(data []byte, filename string) (*models.GPXTrack, error) {
-	reader := bytes.NewReader(data)
+func (s *GPXService) ParseGPXData(data []byte, filename string) (track *models.GPXTrack, err error) {
+	return s.ParseGPXReader(bytes.NewReader(data), filename)
+}
+
+// ParseGPXReader parses a GPX document as it streams from r, so the raw file never has to
+// be held in memory. The result is the same as ParseGPXData on the same bytes.
+func (s *GPXService) ParseGPXReader(r io.Reader, filename string) (track *models.GPXTrack, err error) {
+	defer recoverParsePanic(&err)
+
+	// gpxgo sniffs the version from a single Read of up to 1000 bytes; stream readers such
+	// as tar entries can return less, so fill the buffer first
+	reader := bufio.NewReaderSize(r, 4096)
+	reader.Peek(1000)
 
 	gpxData, err := gpx.Parse(reader)
 	if err != nil {
@@ -47,19 +109,42 @@ func (s *GPXService) ParseGPXData(data []byte, filename string) (*models.GPXTrac
 	return s.processGPXData(gpxData, filename)
 }
 
+// recoverParsePanic converts a panic raised inside gpxgo (or our own processing of a
+// malformed file) into an ordinary parse error, so one bad file can't crash the server.
+func recoverParsePanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic while parsing GPX: %v", r)
+	}
+}
+
+// processGPXData converts a parsed GPX file into a single track record. Files with
+// several <trk> elements are merged: the segments of every track are concatenated in
+// file order, name/description/type come from the first track, and SourceTrackCount
+// records how many tracks were combined. All stats are computed over the merged points.
+// Files without tracks fall back to their first route, read as a one-segment track.
 func (s *GPXService) processGPXData(gpxData *gpx.GPX, filename string) (*models.GPXTrack, error) {
+	if len(gpxData.Tracks) == 0 && len(gpxData.Routes) > 0 {
+		route := gpxData.Routes[0]
+		gpxData.Tracks = []gpx.GPXTrack{{
+			Name:        route.Name,
+			Description: route.Description,
+			Type:        route.Type,
+			Segments:    []gpx.GPXTrackSegment{{Points: route.Points}},
+		}}
+	}
 	if len(gpxData.Tracks) == 0 {
-		return nil, fmt.Errorf("no tracks found in GPX file")
+		return nil, fmt.Errorf("no tracks or routes found in GPX file")
 	}
 
-	// Use the first track
+	// Metadata comes from the first track
 	track := gpxData.Tracks[0]
 
 	// Create the track model
 	gpxTrack := &models.GPXTrack{
-		Filename:    filename,
-		Name:        track.Name,
-		TrackPoints: []models.TrackPoint{},
+		Filename:         filename,
+		Name:             track.Name,
+		SourceTrackCount: len(gpxData.Tracks),
+		TrackPoints:      []models.TrackPoint{},
 	}
 
 	if track.Description != "" {
@@ -75,131 +160,99 @@ func (s *GPXService) processGPXData(gpxData *gpx.GPX, filename string) (*models.
 		gpxTrack.Keywords = &gpxData.Keywords
 	}
 
-	// Initialize bounds
-	var minLat, maxLat, minLon, maxLon float64
-	var minEle, maxEle float64
-	var totalDistance, totalElevationGain, totalElevationLoss float64
-	var startTime, endTime *time.Time
-
-	firstPoint := true
-	var prevPoint *models.TrackPoint
-	var prevElevation *float64
-
-	// Process all track segments and points
-	for _, segment := range track.Segments {
-		for _, point := range segment.Points {
-			trackPoint := models.TrackPoint{
-				Latitude:  point.Latitude,
-				Longitude: point.Longitude,
-			}
-
-			if point.Elevation.NotNull() {
-				elevation := point.Elevation.Value()
-				trackPoint.Elevation = &elevation
-			}
+	// Keep file-level metadata for attribution on re-export
+	if gpxData.Creator != "" {
+		gpxTrack.Creator = &gpxData.Creator
+	}
+	if gpxData.AuthorName != "" {
+		gpxTrack.Author = &gpxData.AuthorName
+	}
+	if gpxData.Time != nil && !gpxData.Time.IsZero() {
+		gpxTrack.MetadataTime = gpxData.Time
+	}
 
-			if !point.Timestamp.IsZero() {
-				trackPoint.Time = &point.Timestamp
-			}
+	// Extract named waypoints (POIs) stored at the file level
+	for _, wpt := range gpxData.Waypoints {
+		if !validCoordinate(wpt.Latitude, wpt.Longitude) {
+			continue
+		}
+		waypoint := models.Waypoint{
+			Latitude:  wpt.Latitude,
+			Longitude: wpt.Longitude,
+			Name:      wpt.Name,
+		}
+		if wpt.Elevation.NotNull() {
+			elevation := wpt.Elevation.Value()
+			waypoint.Elevation = &elevation
+		}
+		if wpt.Description != "" {
+			description := wpt.Description
+			waypoint.Description = &description
+		}
+		if wpt.Symbol != "" {
+			symbol := wpt.Symbol
+			waypoint.Symbol = &symbol
+		}
+		gpxTrack.Waypoints = append(gpxTrack.Waypoints, waypoint)
+	}
 
-			// Update bounds
-			if firstPoint {
-				minLat, maxLat = point.Latitude, point.Latitude
-				minLon, maxLon = point.Longitude, point.Longitude
-				if trackPoint.Elevation != nil {
-					minEle, maxEle = *trackPoint.Elevation, *trackPoint.Elevation
-				}
-				if trackPoint.Time != nil {
-					startTime = trackPoint.Time
-					endTime = trackPoint.Time
-				}
-				firstPoint = false
-			} else {
-				if point.Latitude < minLat {
-					minLat = point.Latitude
-				}
-				if point.Latitude > maxLat {
-					maxLat = point.Latitude
-				}
-				if point.Longitude < minLon {
-					minLon = point.Longitude
-				}
-				if point.Longitude > maxLon {
-					maxLon = point.Longitude
+	// Collect the points of every segment of every track
+	truncated := false
+collect:
+	for _, trk := range gpxData.Tracks {
+		for _, segment := range trk.Segments {
+			for _, point := range segment.Points {
+				if s.maxTrackPoints > 0 && len(gpxTrack.TrackPoints) >= s.maxTrackPoints {
+					if !s.truncatePoints {
+						fmt.Printf("Rejecting %s: more than %d points (MAX_TRACK_POINTS)\n", filename, s.maxTrackPoints)
+						return nil, fmt.Errorf("track has more than %d points", s.maxTrackPoints)
+					}
+					truncated = true
+					break collect
 				}
 
-				if trackPoint.Elevation != nil {
-					if *trackPoint.Elevation < minEle {
-						minEle = *trackPoint.Elevation
-					}
-					if *trackPoint.Elevation > maxEle {
-						maxEle = *trackPoint.Elevation
-					}
+				// Out-of-range glitches and (0,0) spikes would corrupt bounds and the geohash
+				if !validCoordinate(point.Latitude, point.Longitude) {
+					gpxTrack.DroppedPointCount++
+					continue
 				}
 
-				if trackPoint.Time != nil {
-					if startTime == nil || trackPoint.Time.Before(*startTime) {
-						startTime = trackPoint.Time
-					}
-					if endTime == nil || trackPoint.Time.After(*endTime) {
-						endTime = trackPoint.Time
-					}
+				trackPoint := models.TrackPoint{
+					Latitude:  point.Latitude,
+					Longitude: point.Longitude,
 				}
-			}
 
-			// Calculate distance from previous point
-			if prevPoint != nil {
-				distance := haversineDistance(
-					prevPoint.Latitude, prevPoint.Longitude,
-					trackPoint.Latitude, trackPoint.Longitude,
-				)
-				totalDistance += distance
-			}
+				if point.Elevation.NotNull() {
+					elevation := point.Elevation.Value()
+					trackPoint.Elevation = &elevation
+				}
 
-			// Calculate elevation gain/loss
-			if trackPoint.Elevation != nil && prevElevation != nil {
-				elevationDiff := *trackPoint.Elevation - *prevElevation
-				if elevationDiff > 0 {
-					totalElevationGain += elevationDiff
-				} else {
-					totalElevationLoss += math.Abs(elevationDiff)
+				if !point.Timestamp.IsZero() {
+					trackPoint.Time = &point.Timestamp
 				}
-			}
 
-			gpxTrack.TrackPoints = append(gpxTrack.TrackPoints, trackPoint)
-			prevPoint = &trackPoint
-			if trackPoint.Elevation != nil {
-				prevElevation = trackPoint.Elevation
+				gpxTrack.TrackPoints = append(gpxTrack.TrackPoints, trackPoint)
 			}
 		}
 	}
 
-	// Set calculated values
-	gpxTrack.Distance = totalDistance
-	gpxTrack.ElevationGain = totalElevationGain
-	gpxTrack.ElevationLoss = totalElevationLoss
-	gpxTrack.MaxElevation = maxEle
-	gpxTrack.MinElevation = minEle
-	gpxTrack.StartTime = startTime
-	gpxTrack.EndTime = endTime
+	if truncated {
+		fmt.Printf("Truncated %s to its first %d points (MAX_TRACK_POINTS)\n", filename, s.maxTrackPoints)
+	}
 
-	// Calculate duration
-	if startTime != nil && endTime != nil {
-		gpxTrack.Duration = int(endTime.Sub(*startTime).Seconds())
+	if gpxTrack.DroppedPointCount > 0 {
+		fmt.Printf("Dropped %d points with invalid coordinates from %s\n", gpxTrack.DroppedPointCount, filename)
 	}
 
-	// Set bounds
-	gpxTrack.Bounds = models.Bounds{
-		North: maxLat,
-		South: minLat,
-		East:  maxLon,
-		West:  minLon,
+	// Without points the stats would be zeros and the geohash would point at (0,0)
+	if len(gpxTrack.TrackPoints) == 0 {
+		if gpxTrack.DroppedPointCount > 0 {
+			return nil, fmt.Errorf("%w: all %d points have invalid coordinates", ErrNoTrackPoints, gpxTrack.DroppedPointCount)
+		}
+		return nil, ErrNoTrackPoints
 	}
 
-	// Calculate centroid and geohash for spatial indexing
-	centroidLat := (minLat + maxLat) / 2
-	centroidLon := (minLon + maxLon) / 2
-	gpxTrack.Geohash = geohash.Encode(centroidLat, centroidLon)
+	s.computeTrackStats(gpxTrack)
 
 	// If no name is provided, use filename without extension
 	if gpxTrack.Name == "" {
@@ -210,6 +263,66 @@ func (s *GPXService) processGPXData(gpxData *gpx.GPX, filename string) (*models.
 	return gpxTrack, nil
 }
 
+// computeTrackStats fills in the stats of a track from its points, using this service's
+// options. See ComputeTrackStats.
+func (s *GPXService) computeTrackStats(track *models.GPXTrack) {
+	ComputeTrackStats(track.TrackPoints, s.statsOptions).applyTo(track)
+}
+
+// smoothElevations applies a centered moving average over the given window size.
+// Near the ends the window is truncated rather than padded.
+func smoothElevations(elevations []float64, window int) []float64 {
+	if window <= 1 || len(elevations) < 3 {
+		return elevations
+	}
+
+	half := window / 2
+	smoothed := make([]float64, len(elevations))
+	for i := range elevations {
+		start := i - half
+		if start < 0 {
+			start = 0
+		}
+		end := i + half
+		if end > len(elevations)-1 {
+			end = len(elevations) - 1
+		}
+
+		sum := 0.0
+		for j := start; j <= end; j++ {
+			sum += elevations[j]
+		}
+		smoothed[i] = sum / float64(end-start+1)
+	}
+
+	return smoothed
+}
+
+// elevationGainLoss sums climbs and descents. With a positive threshold, a change only
+// counts once the elevation has moved more than threshold meters away from where the last
+// counted change ended, so noise around a level stretch adds nothing; the whole change is
+// then counted. Zero sums every delta between consecutive elevations.
+func elevationGainLoss(elevations []float64, threshold float64) (gain, loss float64) {
+	if len(elevations) == 0 {
+		return 0, 0
+	}
+
+	reference := elevations[0]
+	for _, elevation := range elevations[1:] {
+		diff := elevation - reference
+		if threshold > 0 && math.Abs(diff) <= threshold {
+			continue
+		}
+		if diff > 0 {
+			gain += diff
+		} else {
+			loss -= diff
+		}
+		reference = elevation
+	}
+	return gain, loss
+}
+
 // haversineDistance calculates the distance between two points on Earth
 // using the Haversine formula. Returns distance in meters.
 func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
@@ -233,3 +346,58 @@ func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 
 	return earthRadius * c
 }
+
+// validCoordinate reports whether a point has an in-range latitude and longitude and isn't
+// the (0,0) "null island" some devices emit before they have a fix
+func validCoordinate(lat, lon float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(lon) {
+		return false
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return false
+	}
+	return lat != 0 || lon != 0
+}
+
+// trackCentroid returns the mean position of the points. They are averaged as unit vectors
+// rather than as degrees, so a track crossing the antimeridian stays on its own side of the
+// globe instead of averaging to longitude 0.
+func trackCentroid(points []models.TrackPoint) (lat, lon float64) {
+	if len(points) == 0 {
+		return 0, 0
+	}
+
+	toRadians := math.Pi / 180
+	var x, y, z float64
+	for _, point := range points {
+		phi, lambda := point.Latitude*toRadians, point.Longitude*toRadians
+		x += math.Cos(phi) * math.Cos(lambda)
+		y += math.Cos(phi) * math.Sin(lambda)
+		z += math.Sin(phi)
+	}
+
+	lat = math.Atan2(z, math.Hypot(x, y)) / toRadians
+	lon = math.Atan2(y, x) / toRadians
+	return lat, lon
+}
+
+// centroidOf returns a stored track's centroid, falling back to the center of its bounds
+// for rows that have none
+func centroidOf(track models.GPXTrack) (lat, lon float64) {
+	if track.CentroidLat != 0 || track.CentroidLon != 0 {
+		return track.CentroidLat, track.CentroidLon
+	}
+	return (track.Bounds.North + track.Bounds.South) / 2, (track.Bounds.East + track.Bounds.West) / 2
+}
+
+// boundingBoxArea returns the area in km² of a latitude/longitude box on a spherical Earth.
+// A degree of longitude spans less ground toward the poles, which the difference of the
+// latitude sines accounts for.
+func boundingBoxArea(bounds models.Bounds) float64 {
+	const earthRadiusKm = 6371.0
+	toRadians := math.Pi / 180
+
+	lonSpan := (bounds.East - bounds.West) * toRadians
+	latBand := math.Abs(math.Sin(bounds.North*toRadians) - math.Sin(bounds.South*toRadians))
+	return earthRadiusKm * earthRadiusKm * math.Abs(lonSpan) * latBand
+}
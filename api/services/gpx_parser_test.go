@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// sampleGPX is a short loop with elevations and timestamps
+const sampleGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <name>Sample Loop</name>
+    <trkseg>
+      <trkpt lat="47.3769" lon="8.5417"><ele>408</ele><time>2024-05-01T08:00:00Z</time></trkpt>
+      <trkpt lat="47.3810" lon="8.5480"><ele>415</ele><time>2024-05-01T08:05:00Z</time></trkpt>
+      <trkpt lat="47.3875" lon="8.5502"><ele>431</ele><time>2024-05-01T08:10:00Z</time></trkpt>
+      <trkpt lat="47.3921" lon="8.5431"><ele>452</ele><time>2024-05-01T08:15:00Z</time></trkpt>
+      <trkpt lat="47.3890" lon="8.5340"><ele>440</ele><time>2024-05-01T08:20:00Z</time></trkpt>
+      <trkpt lat="47.3822" lon="8.5329"><ele>420</ele><time>2024-05-01T08:25:00Z</time></trkpt>
+      <trkpt lat="47.3771" lon="8.5410"><ele>409</ele><time>2024-05-01T08:30:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestBoundingCircleCoversAllPoints(t *testing.T) {
+	track, err := NewGPXService().ParseGPXData([]byte(sampleGPX), "sample.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	circle := track.BoundingCircle
+	if circle == nil {
+		t.Fatal("no bounding circle")
+	}
+	if circle.Lat != track.CentroidLat || circle.Lon != track.CentroidLon {
+		t.Fatalf("center = (%v, %v), want the centroid (%v, %v)", circle.Lat, circle.Lon, track.CentroidLat, track.CentroidLon)
+	}
+
+	farthest := 0.0
+	for _, point := range track.TrackPoints {
+		distance := haversineDistance(circle.Lat, circle.Lon, point.Latitude, point.Longitude)
+		if distance > circle.RadiusMeters+1e-6 {
+			t.Errorf("point (%v, %v) is %.2f m from the center, outside the %.2f m radius",
+				point.Latitude, point.Longitude, distance, circle.RadiusMeters)
+		}
+		farthest = max(farthest, distance)
+	}
+	// The radius reaches the farthest point rather than overshooting it
+	if circle.RadiusMeters-farthest > 1e-6 {
+		t.Errorf("radius = %.2f m, farthest point is %.2f m", circle.RadiusMeters, farthest)
+	}
+}
+
+// panicReader panics on its first read, standing in for input that crashes gpxgo
+type panicReader struct{}
+
+func (panicReader) Read([]byte) (int, error) {
+	panic("malformed input")
+}
+
+func TestParseGPXReaderRecoversPanic(t *testing.T) {
+	track, err := NewGPXService().ParseGPXReader(panicReader{}, "bad.gpx")
+	if err == nil {
+		t.Fatal("want an error for a parse that panicked")
+	}
+	if track != nil {
+		t.Fatalf("track = %v, want nil", track)
+	}
+	if !strings.Contains(err.Error(), "malformed input") {
+		t.Fatalf("err = %v, want it to carry the panic value", err)
+	}
+}
+
+// gpx10Sample is shaped like the GPX 1.0 that GPSBabel and older Garmin exports produce:
+// metadata directly under <gpx>, <bounds>, and the 1.0-only <course> and <speed> on points
+const gpx10Sample = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.0" creator="GPSBabel - http://www.gpsbabel.org"
+  xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+  xmlns="http://www.topografix.com/GPX/1/0"
+  xsi:schemaLocation="http://www.topografix.com/GPX/1/0 http://www.topografix.com/GPX/1/0/gpx.xsd">
+<name>Morning Ride</name>
+<author>Jane Rider</author>
+<time>2009-10-17T18:37:26Z</time>
+<bounds minlat="46.570790000" minlon="8.413000000" maxlat="46.572240000" maxlon="8.416200000"/>
+<wpt lat="46.571500000" lon="8.414000000">
+  <ele>2372.000000</ele>
+  <name>Pass</name>
+  <sym>Summit</sym>
+</wpt>
+<trk>
+  <name>ACTIVE LOG</name>
+  <trkseg>
+<trkpt lat="46.570790000" lon="8.413000000">
+  <ele>2376.640000</ele>
+  <time>2009-10-17T18:37:26Z</time>
+  <course>45.000000</course>
+  <speed>2.100000</speed>
+</trkpt>
+<trkpt lat="46.571220000" lon="8.414200000">
+  <ele>2375.450000</ele>
+  <time>2009-10-17T18:37:31Z</time>
+  <course>62.000000</course>
+  <speed>2.300000</speed>
+</trkpt>
+<trkpt lat="46.571730000" lon="8.415300000">
+  <ele>2372.150000</ele>
+  <time>2009-10-17T18:37:34Z</time>
+  <course>58.000000</course>
+  <speed>2.400000</speed>
+</trkpt>
+<trkpt lat="46.572240000" lon="8.416200000">
+  <ele>2369.030000</ele>
+  <time>2009-10-17T18:37:40Z</time>
+  <course>51.000000</course>
+  <speed>2.000000</speed>
+</trkpt>
+  </trkseg>
+</trk>
+</gpx>`
+
+func TestParseGPX10(t *testing.T) {
+	track, err := NewGPXService().ParseGPXData([]byte(gpx10Sample), "ride.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if track.Name != "ACTIVE LOG" {
+		t.Errorf("name = %q, want the track name", track.Name)
+	}
+	if track.Author == nil || *track.Author != "Jane Rider" {
+		t.Errorf("author = %v, want Jane Rider", track.Author)
+	}
+	if track.Creator == nil || !strings.HasPrefix(*track.Creator, "GPSBabel") {
+		t.Errorf("creator = %v, want GPSBabel", track.Creator)
+	}
+	if len(track.TrackPoints) != 4 || len(track.Waypoints) != 1 {
+		t.Fatalf("got %d points and %d waypoints, want 4 and 1", len(track.TrackPoints), len(track.Waypoints))
+	}
+	if track.Duration != 14 {
+		t.Errorf("duration = %d s, want 14", track.Duration)
+	}
+	if track.MaxElevation != 2376.64 || track.MinElevation != 2369.03 {
+		t.Errorf("elevation range = %v..%v", track.MinElevation, track.MaxElevation)
+	}
+	if track.Bounds.North != 46.57224 || track.Bounds.West != 8.413 {
+		t.Errorf("bounds = %+v", track.Bounds)
+	}
+}
+
+func TestParseRouteOnlyGPX(t *testing.T) {
+	const routeGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="planner" xmlns="http://www.topografix.com/GPX/1/1">
+  <rte>
+    <name>Planned Route</name>
+    <desc>Lake shore</desc>
+    <rtept lat="47.3500" lon="8.5400"><ele>406</ele></rtept>
+    <rtept lat="47.3400" lon="8.5600"><ele>410</ele></rtept>
+    <rtept lat="47.3300" lon="8.5800"><ele>412</ele></rtept>
+  </rte>
+</gpx>`
+
+	track, err := NewGPXService().ParseGPXData([]byte(routeGPX), "route.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Planned Route" || track.Description == nil || *track.Description != "Lake shore" {
+		t.Errorf("name/description = %q/%v, want the route's", track.Name, track.Description)
+	}
+	if len(track.TrackPoints) != 3 || track.PointCount != 3 {
+		t.Fatalf("got %d points, want the 3 route points", len(track.TrackPoints))
+	}
+	if track.Distance < 3000 || track.HasTimestamps {
+		t.Errorf("distance = %.0f m, has timestamps = %v", track.Distance, track.HasTimestamps)
+	}
+}
+
+func TestParseGPXWithoutPoints(t *testing.T) {
+	tests := []struct {
+		name, data string
+	}{
+		{"empty segment", `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><name>Nothing</name><trkseg></trkseg></trk>
+</gpx>`},
+		{"no segments", `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><name>Nothing</name></trk>
+</gpx>`},
+		{"only invalid points", `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg><trkpt lat="0" lon="0"></trkpt><trkpt lat="95" lon="8"></trkpt></trkseg></trk>
+</gpx>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := NewGPXService().ParseGPXData([]byte(tt.data), "empty.gpx")
+			if !errors.Is(err, ErrNoTrackPoints) {
+				t.Fatalf("err = %v, want ErrNoTrackPoints", err)
+			}
+			if track != nil {
+				t.Fatalf("track = %+v, want nil", track)
+			}
+		})
+	}
+}
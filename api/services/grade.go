@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+
+	"mytracks-api/config"
+	"mytracks-api/models"
+)
+
+// defaultGradeSegmentMeters is the shortest stretch a grade is computed over; shorter
+// distances turn a few meters of elevation noise into absurd percentages
+const defaultGradeSegmentMeters = 100.0
+
+// GradeSegment is a resampled stretch of track with its average gradient
+type GradeSegment struct {
+	StartDistanceMeters float64 `json:"start_distance_m"`
+	DistanceMeters      float64 `json:"distance_m"`
+	ElevationChange     float64 `json:"elevation_change_m"`
+	GradePercent        float64 `json:"grade_percent"`
+}
+
+// GetGradeProfile returns the track's gradient profile. Elevations are smoothed with the
+// same moving average used for elevation gain, then points are grouped into segments of
+// at least GRADE_MIN_SEGMENT_METERS (default 100 m); a shorter remainder at the end is
+// dropped. Points without elevation are skipped.
+func (s *TrackService) GetGradeProfile(ctx context.Context, id uint) ([]GradeSegment, error) {
+	var track models.GPXTrack
+	if err := s.db.WithContext(ctx).Select("id").First(&track, id).Error; err != nil {
+		return nil, err
+	}
+
+	var points []models.TrackPoint
+	err := s.db.WithContext(ctx).Where("track_id = ? AND elevation IS NOT NULL", id).Order("id").Find(&points).Error
+	if err != nil {
+		return nil, err
+	}
+
+	minSegment := config.Float("GRADE_MIN_SEGMENT_METERS", defaultGradeSegmentMeters)
+	if minSegment <= 0 {
+		minSegment = defaultGradeSegmentMeters
+	}
+
+	return computeGradeProfile(points, s.gpxService.statsOptions.SmoothingWindow, minSegment), nil
+}
+
+// computeGradeProfile groups consecutive points into segments of at least minSegment
+// meters and reports the smoothed elevation change and grade of each
+func computeGradeProfile(points []models.TrackPoint, smoothingWindow int, minSegment float64) []GradeSegment {
+	segments := []GradeSegment{}
+	if len(points) < 2 {
+		return segments
+	}
+
+	elevations := make([]float64, len(points))
+	for i, point := range points {
+		elevations[i] = *point.Elevation
+	}
+	elevations = smoothElevations(elevations, smoothingWindow)
+
+	var cumulative, segmentDistance float64
+	segmentStart := 0
+	for i := 1; i < len(points); i++ {
+		prev, point := points[i-1], points[i]
+		step := haversineDistance(prev.Latitude, prev.Longitude, point.Latitude, point.Longitude)
+		segmentDistance += step
+
+		if segmentDistance >= minSegment {
+			change := elevations[i] - elevations[segmentStart]
+			segments = append(segments, GradeSegment{
+				StartDistanceMeters: cumulative,
+				DistanceMeters:      segmentDistance,
+				ElevationChange:     change,
+				GradePercent:        change / segmentDistance * 100,
+			})
+			cumulative += segmentDistance
+			segmentDistance = 0
+			segmentStart = i
+		}
+	}
+
+	return segments
+}
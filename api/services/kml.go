@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"mytracks-api/models"
+)
+
+// GetKMLData returns the track as a KML document along with a download filename
+func (s *TrackService) GetKMLData(ctx context.Context, id uint) ([]byte, string, error) {
+	// Get track with all points
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints").First(&track, id).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	kml := s.GenerateKML(track)
+	filename := fmt.Sprintf("track_%d.kml", id)
+	if track.Filename != "" {
+		filename = strings.TrimSuffix(track.Filename, filepath.Ext(track.Filename)) + ".kml"
+	}
+
+	return []byte(kml), filename, nil
+}
+
+// GenerateKML builds a KML 2.2 document with a single LineString placemark for Google Earth
+func (s *TrackService) GenerateKML(track models.GPXTrack) string {
+	var kml strings.Builder
+
+	kml.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	kml.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2">`)
+	kml.WriteString(`<Document>`)
+
+	// Document metadata
+	if track.Name != "" {
+		kml.WriteString(fmt.Sprintf(`<name>%s</name>`, xmlEscape(track.Name)))
+	}
+	if track.Description != nil && *track.Description != "" {
+		kml.WriteString(fmt.Sprintf(`<description>%s</description>`, xmlEscape(*track.Description)))
+	}
+
+	kml.WriteString(`<Placemark>`)
+	if track.Name != "" {
+		kml.WriteString(fmt.Sprintf(`<name>%s</name>`, xmlEscape(track.Name)))
+	}
+
+	kml.WriteString(`<LineString>`)
+	kml.WriteString(`<tessellate>1</tessellate>`)
+	kml.WriteString(`<altitudeMode>absolute</altitudeMode>`)
+	kml.WriteString(`<coordinates>`)
+
+	// Coordinates are whitespace-separated lon,lat,ele tuples
+	for i, point := range track.TrackPoints {
+		if i > 0 {
+			kml.WriteString(" ")
+		}
+		elevation := 0.0
+		if point.Elevation != nil {
+			elevation = *point.Elevation
+		}
+		kml.WriteString(fmt.Sprintf("%.6f,%.6f,%.2f", point.Longitude, point.Latitude, elevation))
+	}
+
+	kml.WriteString(`</coordinates>`)
+	kml.WriteString(`</LineString>`)
+	kml.WriteString(`</Placemark>`)
+	kml.WriteString(`</Document>`)
+	kml.WriteString(`</kml>`)
+
+	return kml.String()
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrTooFewTracks is returned when fewer than two distinct tracks are given to merge
+var ErrTooFewTracks = errors.New("merging needs at least two distinct tracks")
+
+// MergeTracks creates a new track from the points of several tracks, recomputing stats and
+// bounds over the combined points. Tracks are concatenated in start-time order when every
+// one of them has timestamps, and in the given order otherwise. Waypoints are carried over,
+// and an empty name falls back to the first track's name. When deleteSources is set the
+// source tracks are soft-deleted in the same transaction.
+func (s *TrackService) MergeTracks(ctx context.Context, ids []uint, name string, deleteSources bool) (*models.GPXTrack, error) {
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	if len(seen) < 2 || len(seen) != len(ids) {
+		return nil, ErrTooFewTracks
+	}
+
+	defer s.boundsCache.invalidate()
+	var found []models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Waypoints").Find(&found, ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]models.GPXTrack, len(found))
+	for _, track := range found {
+		byID[track.ID] = track
+	}
+	sources := make([]models.GPXTrack, len(ids))
+	allTimed := true
+	for i, id := range ids {
+		track, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("track %d: %w", id, gorm.ErrRecordNotFound)
+		}
+		sources[i] = track
+		allTimed = allTimed && track.StartTime != nil
+	}
+	if allTimed {
+		sort.SliceStable(sources, func(i, j int) bool {
+			return sources[i].StartTime.Before(*sources[j].StartTime)
+		})
+	}
+
+	first := sources[0]
+	merged := &models.GPXTrack{
+		Filename:    fmt.Sprintf("merged_%d.gpx", time.Now().UnixNano()),
+		Name:        name,
+		Description: first.Description,
+		Type:        first.Type,
+		Keywords:    first.Keywords,
+		Creator:     first.Creator,
+		Author:      first.Author,
+	}
+	if merged.Name == "" {
+		merged.Name = first.Name
+	}
+
+	// Fresh copies so the new rows get their own IDs
+	for _, source := range sources {
+		merged.SourceTrackCount += source.SourceTrackCount
+		merged.DroppedPointCount += source.DroppedPointCount
+		for _, point := range source.TrackPoints {
+			merged.TrackPoints = append(merged.TrackPoints, models.TrackPoint{
+				Latitude:  point.Latitude,
+				Longitude: point.Longitude,
+				Elevation: point.Elevation,
+				Time:      point.Time,
+			})
+		}
+		for _, waypoint := range source.Waypoints {
+			merged.Waypoints = append(merged.Waypoints, models.Waypoint{
+				Latitude:    waypoint.Latitude,
+				Longitude:   waypoint.Longitude,
+				Elevation:   waypoint.Elevation,
+				Name:        waypoint.Name,
+				Description: waypoint.Description,
+				Symbol:      waypoint.Symbol,
+			})
+		}
+	}
+
+	s.gpxService.computeTrackStats(merged)
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.createTrackWithPoints(tx, merged); err != nil {
+			return err
+		}
+		if deleteSources {
+			return tx.Delete(&models.GPXTrack{}, ids).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// MaxNearbyRadiusKm caps the search radius for nearby-track queries
+const MaxNearbyRadiusKm = 500.0
+
+// maxNearbyCandidates caps how many tracks a nearby query loads before computing exact
+// distances, so a large radius can't pull the whole table into memory
+const maxNearbyCandidates = 5000
+
+// NearbyTrack is a track annotated with its distance from the query point
+type NearbyTrack struct {
+	models.GPXTrack
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// GetTracksNear returns tracks whose bounds centroid lies within radiusKm of the given
+// point, sorted nearest-first. Candidates are prefiltered in SQL by the geohash cells and
+// bounds of a box around the radius, and at most maxNearbyCandidates of them are loaded
+// before exact haversine distances are computed.
+func (s *TrackService) GetTracksNear(ctx context.Context, lat, lon, radiusKm float64, limit int) ([]NearbyTrack, error) {
+	radiusKm = math.Min(radiusKm, MaxNearbyRadiusKm)
+
+	box := nearbyBounds(lat, lon, radiusKm)
+	north, south, east, west := box.North, box.South, box.East, box.West
+
+	query := s.db.WithContext(ctx).Model(&models.GPXTrack{})
+	if cells := geohashCover(north, south, east, west); len(cells) > 0 {
+		condition, args := geohashCoverClause(cells)
+		query = query.Where(condition, args...)
+	}
+	condition, args := boundsClause(north, south, east, west)
+	query = query.Where(condition, args...)
+
+	// Bound the rows loaded into memory, keeping the candidates whose centroids are nearest
+	// by a flat approximation that wraps at the antimeridian
+	cosLat := math.Cos(lat * math.Pi / 180)
+	var candidates []models.GPXTrack
+	err := query.
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "POWER(centroid_lat - ?, 2) + POWER(LEAST(ABS(centroid_lon - ?), 360 - ABS(centroid_lon - ?)) * ?, 2)",
+			Vars: []interface{}{lat, lon, lon, cosLat},
+		}}).
+		Limit(maxNearbyCandidates).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute exact distances to each candidate's centroid and filter by radius
+	var results []NearbyTrack
+	for _, track := range candidates {
+		centroidLat, centroidLon := centroidOf(track)
+		distanceKm := haversineDistance(lat, lon, centroidLat, centroidLon) / 1000
+		if distanceKm <= radiusKm {
+			results = append(results, NearbyTrack{GPXTrack: track, DistanceKm: distanceKm})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].DistanceKm < results[j].DistanceKm
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// nearbyBounds returns a box around the circle of radiusKm at lat/lon, with longitudes in
+// -180..180. The longitude span is sized at the box's poleward edge, where degrees are
+// narrowest; a box reaching a pole spans every longitude, and one crossing the
+// antimeridian comes back with west > east.
+func nearbyBounds(lat, lon, radiusKm float64) models.Bounds {
+	latDelta := radiusKm / 111.32
+	box := models.Bounds{
+		North: math.Min(90, lat+latDelta),
+		South: math.Max(-90, lat-latDelta),
+		West:  -180,
+		East:  180,
+	}
+
+	edgeLat := math.Max(math.Abs(box.North), math.Abs(box.South))
+	cosLat := math.Cos(edgeLat * math.Pi / 180)
+	if cosLat <= 0.01 {
+		return box
+	}
+	lonDelta := radiusKm / (111.32 * cosLat)
+	if lonDelta >= 180 {
+		return box
+	}
+
+	box.West, box.East = lon-lonDelta, lon+lonDelta
+	if box.West < -180 {
+		box.West += 360
+	}
+	if box.East > 180 {
+		box.East -= 360
+	}
+	return box
+}
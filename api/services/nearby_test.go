@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"mytracks-api/models"
+)
+
+func TestNearbyBounds(t *testing.T) {
+	tests := []struct {
+		name               string
+		lat, lon, radiusKm float64
+		check              func(t *testing.T, box models.Bounds)
+	}{
+		{"ordinary", 47, 8, 10, func(t *testing.T, box models.Bounds) {
+			if box.West >= box.East || box.West < 7.8 || box.East > 8.2 {
+				t.Errorf("longitudes %v..%v, want a narrow range around 8", box.West, box.East)
+			}
+		}},
+		{"near a pole", 89.9, 10, 50, func(t *testing.T, box models.Bounds) {
+			if box.North != 90 || box.West != -180 || box.East != 180 {
+				t.Errorf("got %+v, want every longitude up to the pole", box)
+			}
+		}},
+		{"across the antimeridian", -17, 179.5, 100, func(t *testing.T, box models.Bounds) {
+			if box.West <= box.East {
+				t.Errorf("longitudes %v..%v, want west > east", box.West, box.East)
+			}
+			if box.West < 178 || box.East > -178 {
+				t.Errorf("longitudes %v..%v, want about 178.6..-179.6", box.West, box.East)
+			}
+		}},
+		{"reaching almost to a pole", 85, 0, 500, func(t *testing.T, box models.Bounds) {
+			if box.West != -180 || box.East != 180 {
+				t.Errorf("longitudes %v..%v, want the full range", box.West, box.East)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box := nearbyBounds(tt.lat, tt.lon, tt.radiusKm)
+			if box.West < -180 || box.West > 180 || box.East < -180 || box.East > 180 {
+				t.Fatalf("longitudes %v..%v out of range", box.West, box.East)
+			}
+			tt.check(t, box)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// neighborDistanceRatio is how many times longer or shorter than the source track a
+	// similar track may be
+	neighborDistanceRatio = 2.0
+	// neighborCandidateLimit caps the candidates scored per request, closest in distance first
+	neighborCandidateLimit = 500
+)
+
+// SimilarTrack is a track annotated with how similar it is to the source track, from 0 to 1
+type SimilarTrack struct {
+	models.GPXTrack
+	Similarity float64 `json:"similarity"`
+}
+
+// FindSimilarTracks returns up to limit tracks whose bounds overlap the given track's and
+// whose distance is within a factor of neighborDistanceRatio of it, most similar first.
+// The similarity is the mean of the bounds overlap (intersection over union) and the
+// distance closeness (shorter over longer distance). The track itself is excluded.
+func (s *TrackService) FindSimilarTracks(ctx context.Context, id uint, limit int) ([]SimilarTrack, error) {
+	db := s.db.WithContext(ctx)
+
+	var track models.GPXTrack
+	if err := db.First(&track, id).Error; err != nil {
+		return nil, err
+	}
+	if track.Distance <= 0 {
+		return []SimilarTrack{}, nil
+	}
+
+	var candidates []models.GPXTrack
+	condition, args := boundsClause(track.Bounds.North, track.Bounds.South, track.Bounds.East, track.Bounds.West)
+	err := db.Where(condition, args...).
+		Where("id <> ?", track.ID).
+		Where("distance BETWEEN ? AND ?", track.Distance/neighborDistanceRatio, track.Distance*neighborDistanceRatio).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ABS(distance - ?), id",
+			Vars: []interface{}{track.Distance},
+		}}).
+		Limit(neighborCandidateLimit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	similar := make([]SimilarTrack, 0, len(candidates))
+	for _, candidate := range candidates {
+		closeness := math.Min(track.Distance, candidate.Distance) / math.Max(track.Distance, candidate.Distance)
+		overlap := boundsOverlap(track.Bounds, candidate.Bounds)
+		similar = append(similar, SimilarTrack{GPXTrack: candidate, Similarity: (overlap + closeness) / 2})
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Similarity > similar[j].Similarity
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// boundsOverlap returns the intersection over union of two bounding boxes, in degrees.
+// Two boxes without any area, such as identical single points, count as fully overlapping.
+func boundsOverlap(a, b models.Bounds) float64 {
+	width := math.Min(a.East, b.East) - math.Max(a.West, b.West)
+	height := math.Min(a.North, b.North) - math.Max(a.South, b.South)
+	if width < 0 || height < 0 {
+		return 0
+	}
+
+	intersection := width * height
+	union := (a.East-a.West)*(a.North-a.South) + (b.East-b.West)*(b.North-b.South) - intersection
+	if union <= 0 {
+		return 1
+	}
+	return intersection / union
+}
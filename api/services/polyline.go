@@ -0,0 +1,40 @@
+package services
+
+import (
+	"math"
+	"strings"
+)
+
+// encodePolyline encodes coordinates using Google's encoded polyline algorithm with
+// precision 5. Elevation is not part of the format and is dropped.
+func encodePolyline(points []TrackCoordinate) string {
+	var encoded strings.Builder
+
+	var prevLat, prevLon int64
+	for _, point := range points {
+		lat := int64(math.Round(point.Latitude * 1e5))
+		lon := int64(math.Round(point.Longitude * 1e5))
+
+		encodePolylineValue(&encoded, lat-prevLat)
+		encodePolylineValue(&encoded, lon-prevLon)
+
+		prevLat, prevLon = lat, lon
+	}
+
+	return encoded.String()
+}
+
+// encodePolylineValue writes a single signed delta as a sequence of 5-bit chunks
+func encodePolylineValue(encoded *strings.Builder, value int64) {
+	// Left-shift and invert negative values so the sign lives in the lowest bit
+	shifted := value << 1
+	if value < 0 {
+		shifted = ^shifted
+	}
+
+	for shifted >= 0x20 {
+		encoded.WriteByte(byte((0x20 | (shifted & 0x1f)) + 63))
+		shifted >>= 5
+	}
+	encoded.WriteByte(byte(shifted + 63))
+}
@@ -0,0 +1,31 @@
+package services
+
+import "testing"
+
+func TestEncodePolyline(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []TrackCoordinate
+		want   string
+	}{
+		// The example from Google's polyline algorithm documentation
+		{"reference", []TrackCoordinate{
+			{Latitude: 38.5, Longitude: -120.2},
+			{Latitude: 40.7, Longitude: -120.95},
+			{Latitude: 43.252, Longitude: -126.453},
+		}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{"single point", []TrackCoordinate{{Latitude: 38.5, Longitude: -120.2}}, "_p~iF~ps|U"},
+		{"repeated point", []TrackCoordinate{
+			{Latitude: 38.5, Longitude: -120.2},
+			{Latitude: 38.5, Longitude: -120.2},
+		}, "_p~iF~ps|U??"},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodePolyline(tt.points); got != tt.want {
+				t.Fatalf("encodePolyline = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
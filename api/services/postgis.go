@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidWKT is returned when a geometry can't be read as WKT with lon/lat coordinates
+var ErrInvalidWKT = errors.New("invalid WKT geometry")
+
+// setTrackRoute builds the PostGIS route line of a track from its stored points, in
+// recorded order. Tracks with fewer than two points keep a NULL route.
+func setTrackRoute(tx *gorm.DB, trackID uint) error {
+	return tx.Exec(`UPDATE gpx_tracks SET route = (
+			SELECT ST_MakeLine(ST_SetSRID(ST_MakePoint(longitude, latitude), 4326) ORDER BY id)
+			FROM track_points WHERE track_id = ?
+			HAVING COUNT(*) >= 2)
+		WHERE id = ?`, trackID, trackID).Error
+}
+
+// GetTracksIntersecting returns tracks whose route intersects the WKT geometry (lon/lat,
+// WGS 84), newest first. With USE_POSTGIS the exact route lines are tested; otherwise this
+// falls back to the bounds query over the geometry's bounding box, which can include
+// tracks that only come near it.
+func (s *TrackService) GetTracksIntersecting(ctx context.Context, wkt string, limit int) ([]models.GPXTrack, error) {
+	north, south, east, west, err := wktBounds(wkt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.usePostGIS {
+		return s.GetTracksByBounds(ctx, north, south, east, west, limit)
+	}
+
+	var tracks []models.GPXTrack
+	err = s.db.WithContext(ctx).Model(&models.GPXTrack{}).
+		Where("route IS NOT NULL AND ST_Intersects(route, ST_GeomFromText(?, 4326))", wkt).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&tracks).Error
+	return tracks, err
+}
+
+// wktBounds returns the bounding box of a WKT geometry by scanning its coordinate pairs.
+// It accepts any geometry type and checks that every pair is a valid lon/lat position;
+// the geometry's structure is left for PostGIS to validate.
+func wktBounds(wkt string) (north, south, east, west float64, err error) {
+	open := strings.Index(wkt, "(")
+	end := strings.LastIndex(wkt, ")")
+	if open <= 0 || end < open {
+		return 0, 0, 0, 0, fmt.Errorf("%w: expected TYPE(coordinates)", ErrInvalidWKT)
+	}
+
+	body := strings.NewReplacer("(", ",", ")", ",").Replace(wkt[open+1 : end])
+	north, south, east, west = math.Inf(-1), math.Inf(1), math.Inf(-1), math.Inf(1)
+	pairs := 0
+	for _, pair := range strings.Split(body, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return 0, 0, 0, 0, fmt.Errorf("%w: coordinate %q needs a longitude and latitude", ErrInvalidWKT, strings.TrimSpace(pair))
+		}
+		lon, lonErr := strconv.ParseFloat(fields[0], 64)
+		lat, latErr := strconv.ParseFloat(fields[1], 64)
+		if lonErr != nil || latErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return 0, 0, 0, 0, fmt.Errorf("%w: coordinate %q is not a lon/lat position", ErrInvalidWKT, strings.TrimSpace(pair))
+		}
+
+		north, south = math.Max(north, lat), math.Min(south, lat)
+		east, west = math.Max(east, lon), math.Min(west, lon)
+		pairs++
+	}
+	if pairs == 0 {
+		return 0, 0, 0, 0, fmt.Errorf("%w: no coordinates", ErrInvalidWKT)
+	}
+
+	return north, south, east, west, nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// ReverseTrack flips the direction of a track and recomputes its stats, which swaps
+// elevation gain and loss. Timestamps are mirrored so the reversed track starts at the
+// original start time with the same intervals between points. With inPlace the track's
+// points are replaced; otherwise a new track is created and the original is left alone.
+func (s *TrackService) ReverseTrack(ctx context.Context, id uint, inPlace bool) (*models.GPXTrack, error) {
+	defer s.boundsCache.invalidate()
+	var original models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Waypoints").First(&original, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	points := reversePoints(original.TrackPoints, original.StartTime, original.EndTime)
+
+	if inPlace {
+		original.TrackPoints = points
+		s.gpxService.computeTrackStats(&original)
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return s.replaceTrackPoints(tx, &original)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &original, nil
+	}
+
+	ext := filepath.Ext(gpxFilename(original))
+	base := strings.TrimSuffix(gpxFilename(original), ext)
+	reversed := &models.GPXTrack{
+		Filename:          fmt.Sprintf("%s_reversed_%d%s", base, time.Now().UnixNano(), ext),
+		Name:              fmt.Sprintf("%s (reversed)", original.Name),
+		Description:       original.Description,
+		Type:              original.Type,
+		Keywords:          original.Keywords,
+		Creator:           original.Creator,
+		Author:            original.Author,
+		MetadataTime:      original.MetadataTime,
+		SourceTrackCount:  original.SourceTrackCount,
+		DroppedPointCount: original.DroppedPointCount,
+		TrackPoints:       points,
+	}
+	for _, waypoint := range original.Waypoints {
+		reversed.Waypoints = append(reversed.Waypoints, models.Waypoint{
+			Latitude:    waypoint.Latitude,
+			Longitude:   waypoint.Longitude,
+			Elevation:   waypoint.Elevation,
+			Name:        waypoint.Name,
+			Description: waypoint.Description,
+			Symbol:      waypoint.Symbol,
+		})
+	}
+	s.gpxService.computeTrackStats(reversed)
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.createTrackWithPoints(tx, reversed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversed, nil
+}
+
+// reversePoints returns fresh copies of points in reverse order. A timestamp t becomes
+// start + (end - t), so the reversed track keeps the original time span and intervals;
+// points without timestamps stay untimed.
+func reversePoints(points []models.TrackPoint, start, end *time.Time) []models.TrackPoint {
+	reversed := make([]models.TrackPoint, len(points))
+	for i, point := range points {
+		copied := models.TrackPoint{
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Elevation: point.Elevation,
+		}
+		if point.Time != nil && start != nil && end != nil {
+			mirrored := start.Add(end.Sub(*point.Time))
+			copied.Time = &mirrored
+		}
+		reversed[len(points)-1-i] = copied
+	}
+	return reversed
+}
+
+// replaceTrackPoints swaps the stored points of an existing track for track.TrackPoints
+// and saves its recomputed stats. Waypoints are left as they are.
+func (s *TrackService) replaceTrackPoints(tx *gorm.DB, track *models.GPXTrack) error {
+	if err := tx.Where("track_id = ?", track.ID).Delete(&models.TrackPoint{}).Error; err != nil {
+		return err
+	}
+
+	points := track.TrackPoints
+	if len(points) > 0 {
+		for i := range points {
+			points[i].TrackID = track.ID
+		}
+		batchSize := s.pointBatchSize
+		if batchSize < 1 {
+			batchSize = defaultPointBatchSize
+		}
+		if err := tx.CreateInBatches(points, batchSize).Error; err != nil {
+			return fmt.Errorf("failed to insert track points: %w", err)
+		}
+	}
+
+	if err := tx.Omit("TrackPoints", "Waypoints").Save(track).Error; err != nil {
+		return err
+	}
+
+	if s.usePostGIS {
+		if err := setTrackRoute(tx, track.ID); err != nil {
+			return fmt.Errorf("failed to build route geometry: %w", err)
+		}
+	}
+	return nil
+}
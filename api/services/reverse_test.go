@@ -0,0 +1,49 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReversePointsSwapsGainAndLoss(t *testing.T) {
+	// Climb 100 m, then descend 40 m
+	n := 30
+	lats, lons, elevations := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i] = 47 + float64(i)*0.0005
+		lons[i] = 8
+		if i < 20 {
+			elevations[i] = 100 + float64(i)*5
+		} else {
+			elevations[i] = 200 - float64(i-19)*4
+		}
+	}
+	points := timedPoints(lats, lons, elevations)
+	// The noise threshold counts from wherever the last counted change ended, which
+	// depends on direction; without it, gain and loss swap exactly
+	opts := NewGPXService().statsOptions
+	opts.GainThreshold = 0
+	forward := ComputeTrackStats(points, opts)
+
+	reversed := reversePoints(points, forward.StartTime, forward.EndTime)
+	backward := ComputeTrackStats(reversed, opts)
+
+	if forward.ElevationGain <= forward.ElevationLoss {
+		t.Fatalf("forward gain/loss = %.1f/%.1f, want more climbing than descending", forward.ElevationGain, forward.ElevationLoss)
+	}
+	if math.Abs(backward.ElevationGain-forward.ElevationLoss) > 1e-9 || math.Abs(backward.ElevationLoss-forward.ElevationGain) > 1e-9 {
+		t.Fatalf("reversed gain/loss = %.1f/%.1f, want %.1f/%.1f",
+			backward.ElevationGain, backward.ElevationLoss, forward.ElevationLoss, forward.ElevationGain)
+	}
+	if math.Abs(backward.Distance-forward.Distance) > 1e-6 || backward.Duration != forward.Duration {
+		t.Errorf("reversed distance/duration = %.1f/%d, want %.1f/%d", backward.Distance, backward.Duration, forward.Distance, forward.Duration)
+	}
+
+	// The reversed track starts where the original ended, at the original start time
+	if reversed[0].Latitude != points[n-1].Latitude || !reversed[0].Time.Equal(*points[0].Time) {
+		t.Errorf("first reversed point = (%v, %v), want the last point at the start time", reversed[0].Latitude, reversed[0].Time)
+	}
+	if !reversed[n-1].Time.Equal(*points[n-1].Time) {
+		t.Errorf("last reversed time = %v, want the original end time", reversed[n-1].Time)
+	}
+}
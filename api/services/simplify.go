@@ -0,0 +1,98 @@
+package services
+
+import (
+	"math"
+
+	"mytracks-api/models"
+)
+
+// simplifyTrack reduces the number of points in a track using the
+// Ramer-Douglas-Peucker algorithm. Tolerance is the maximum allowed
+// perpendicular deviation in meters. The first and last points are always kept.
+func simplifyTrack(points []TrackCoordinate, tolerance float64) []TrackCoordinate {
+	if len(points) <= 2 || tolerance <= 0 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+
+	// Use an explicit stack instead of recursion so very long tracks can't blow the stack
+	type span struct{ start, end int }
+	stack := []span{{0, len(points) - 1}}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		maxDistance := 0.0
+		index := -1
+		for i := current.start + 1; i < current.end; i++ {
+			distance := perpendicularDistance(points[i], points[current.start], points[current.end])
+			if distance > maxDistance {
+				maxDistance = distance
+				index = i
+			}
+		}
+
+		if index != -1 && maxDistance > tolerance {
+			keep[index] = true
+			stack = append(stack, span{current.start, index}, span{index, current.end})
+		}
+	}
+
+	result := make([]TrackCoordinate, 0, len(points))
+	for i, point := range points {
+		if keep[i] {
+			result = append(result, point)
+		}
+	}
+
+	return result
+}
+
+// perpendicularDistance returns the distance in meters from point to the segment
+// between start and end, using a local equirectangular projection.
+func perpendicularDistance(point, start, end TrackCoordinate) float64 {
+	const earthRadius = 6371000 // Earth's radius in meters
+
+	// Project onto a plane centered at the segment start
+	cosLat := math.Cos(start.Latitude * math.Pi / 180)
+	project := func(c TrackCoordinate) (float64, float64) {
+		x := (c.Longitude - start.Longitude) * math.Pi / 180 * earthRadius * cosLat
+		y := (c.Latitude - start.Latitude) * math.Pi / 180 * earthRadius
+		return x, y
+	}
+
+	px, py := project(point)
+	ex, ey := project(end)
+
+	segmentLengthSquared := ex*ex + ey*ey
+	if segmentLengthSquared == 0 {
+		return math.Hypot(px, py)
+	}
+
+	// Clamp the projection to the segment so points beyond the ends measure to the endpoint
+	t := (px*ex + py*ey) / segmentLengthSquared
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(px-t*ex, py-t*ey)
+}
+
+// DecimateTrackPoints keeps every Nth point so that at most maxPoints remain, always
+// including the last point. It's much cheaper than simplifyTrack and good enough for map
+// previews. Tracks within the limit, or a maxPoints below 2, are returned unchanged.
+func DecimateTrackPoints(points []models.TrackPoint, maxPoints int) []models.TrackPoint {
+	if maxPoints < 2 || len(points) <= maxPoints {
+		return points
+	}
+
+	// Reserve one slot for the last point
+	stride := (len(points) - 1 + maxPoints - 2) / (maxPoints - 1)
+	result := make([]models.TrackPoint, 0, maxPoints)
+	for i := 0; i < len(points)-1; i += stride {
+		result = append(result, points[i])
+	}
+	return append(result, points[len(points)-1])
+}
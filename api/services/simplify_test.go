@@ -0,0 +1,105 @@
+package services
+
+import (
+	"testing"
+
+	"mytracks-api/models"
+)
+
+// line returns points spaced along an east-west line at the equator, where
+// 0.001 degrees of longitude is about 111 meters
+func line(n int) []TrackCoordinate {
+	points := make([]TrackCoordinate, n)
+	for i := range points {
+		points[i] = TrackCoordinate{Latitude: 0, Longitude: float64(i) * 0.001}
+	}
+	return points
+}
+
+func TestSimplifyTrackStraightLine(t *testing.T) {
+	got := simplifyTrack(line(50), 1)
+	if len(got) != 2 {
+		t.Fatalf("len = %d, want only the endpoints", len(got))
+	}
+	if got[0].Longitude != 0 || got[1].Longitude != 0.049 {
+		t.Fatalf("endpoints = %v, want the first and last points", got)
+	}
+}
+
+func TestSimplifyTrackKeepsDeviationAboveTolerance(t *testing.T) {
+	points := line(11)
+	// About 111 meters off the line in the middle
+	points[5].Latitude = 0.001
+
+	got := simplifyTrack(points, 50)
+	if !containsCoordinate(got, points[5]) || !containsCoordinate(got, points[0]) || !containsCoordinate(got, points[10]) {
+		t.Fatalf("tolerance 50: got %v, want the endpoints and the peak", got)
+	}
+	if len(got) >= len(points) {
+		t.Fatalf("tolerance 50: len = %d, want fewer than %d", len(got), len(points))
+	}
+	if got := simplifyTrack(points, 200); len(got) != 2 {
+		t.Fatalf("tolerance 200: len = %d, want only the endpoints", len(got))
+	}
+}
+
+func containsCoordinate(points []TrackCoordinate, want TrackCoordinate) bool {
+	for _, point := range points {
+		if point == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSimplifyTrackUnchanged(t *testing.T) {
+	points := line(5)
+	points[2].Latitude = 0.01
+
+	for _, tolerance := range []float64{0, -1} {
+		if got := simplifyTrack(points, tolerance); len(got) != len(points) {
+			t.Errorf("tolerance %v: len = %d, want all %d points", tolerance, len(got), len(points))
+		}
+	}
+	if got := simplifyTrack(points[:2], 1000); len(got) != 2 {
+		t.Errorf("two points: len = %d, want 2", len(got))
+	}
+}
+
+func TestPerpendicularDistance(t *testing.T) {
+	start := TrackCoordinate{Latitude: 0, Longitude: 0}
+	end := TrackCoordinate{Latitude: 0, Longitude: 0.01}
+
+	tests := []struct {
+		name  string
+		point TrackCoordinate
+		want  float64
+	}{
+		{"on the segment", TrackCoordinate{Latitude: 0, Longitude: 0.005}, 0},
+		{"beside the segment", TrackCoordinate{Latitude: 0.001, Longitude: 0.005}, 111.19},
+		{"beyond the end", TrackCoordinate{Latitude: 0, Longitude: 0.011}, 111.19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := perpendicularDistance(tt.point, start, end)
+			if got < tt.want-0.1 || got > tt.want+0.1 {
+				t.Fatalf("distance = %.2f, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimateTrackPoints(t *testing.T) {
+	points := make([]models.TrackPoint, 101)
+	for i := range points {
+		points[i].ID = uint(i)
+	}
+
+	got := DecimateTrackPoints(points, 10)
+	if len(got) > 10 {
+		t.Fatalf("len = %d, want at most 10", len(got))
+	}
+	if got[0].ID != 0 || got[len(got)-1].ID != 100 {
+		t.Fatalf("kept %d..%d, want the first and last points", got[0].ID, got[len(got)-1].ID)
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mytracks-api/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoTimeGaps is returned when a track has no gap long enough to split on
+var ErrNoTimeGaps = errors.New("track has no time gaps to split on")
+
+// SplitByTimeGap creates a new track for each run of points separated by more than gap,
+// recomputing stats and bounds for each part. Points without timestamps stay with the
+// preceding part. When deleteOriginal is set the original track is soft-deleted in the
+// same transaction. It returns the IDs of the new tracks in order.
+func (s *TrackService) SplitByTimeGap(ctx context.Context, id uint, gap time.Duration, deleteOriginal bool) ([]uint, error) {
+	defer s.boundsCache.invalidate()
+	var original models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&original, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	segments := splitPointsByTimeGap(original.TrackPoints, gap)
+	if len(segments) < 2 {
+		return nil, ErrNoTimeGaps
+	}
+
+	base := strings.TrimSuffix(gpxFilename(original), filepath.Ext(gpxFilename(original)))
+	ext := filepath.Ext(gpxFilename(original))
+	// The filename index covers soft-deleted rows too, so repeated splits need fresh names
+	stamp := time.Now().UnixNano()
+
+	parts := make([]*models.GPXTrack, len(segments))
+	for i, points := range segments {
+		part := &models.GPXTrack{
+			Filename:         fmt.Sprintf("%s_part%d_%d%s", base, i+1, stamp, ext),
+			Name:             fmt.Sprintf("%s (part %d)", original.Name, i+1),
+			Description:      original.Description,
+			Type:             original.Type,
+			Keywords:         original.Keywords,
+			Creator:          original.Creator,
+			Author:           original.Author,
+			MetadataTime:     original.MetadataTime,
+			SourceTrackCount: 1,
+		}
+
+		// Fresh copies so the new rows get their own IDs
+		part.TrackPoints = make([]models.TrackPoint, len(points))
+		for j, point := range points {
+			part.TrackPoints[j] = models.TrackPoint{
+				Latitude:  point.Latitude,
+				Longitude: point.Longitude,
+				Elevation: point.Elevation,
+				Time:      point.Time,
+			}
+		}
+
+		s.gpxService.computeTrackStats(part)
+		parts[i] = part
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, part := range parts {
+			if err := s.createTrackWithPoints(tx, part); err != nil {
+				return fmt.Errorf("track %s: %w", part.Filename, err)
+			}
+		}
+		if deleteOriginal {
+			return tx.Delete(&models.GPXTrack{}, id).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(parts))
+	for i, part := range parts {
+		ids[i] = part.ID
+	}
+	return ids, nil
+}
+
+// splitPointsByTimeGap cuts points wherever two consecutive timestamped points are more
+// than gap apart. Untimed points never start a new segment.
+func splitPointsByTimeGap(points []models.TrackPoint, gap time.Duration) [][]models.TrackPoint {
+	var segments [][]models.TrackPoint
+	start := 0
+	var lastTime *time.Time
+
+	for i, point := range points {
+		if point.Time == nil {
+			continue
+		}
+		if lastTime != nil && point.Time.Sub(*lastTime) > gap {
+			segments = append(segments, points[start:i])
+			start = i
+		}
+		lastTime = point.Time
+	}
+
+	if start < len(points) {
+		segments = append(segments, points[start:])
+	}
+	return segments
+}
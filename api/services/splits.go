@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"mytracks-api/models"
+)
+
+// Split is the stretch of a track covering one distance interval
+type Split struct {
+	Index            int      `json:"index"`
+	DistanceMeters   float64  `json:"distance_m"`
+	ElapsedSeconds   *float64 `json:"elapsed_seconds"`     // nil when the track lacks timestamps
+	PaceSecondsPerKm *float64 `json:"pace_seconds_per_km"` // nil when the track lacks timestamps
+	ElevationChange  *float64 `json:"elevation_change"`    // nil when the track lacks elevations
+}
+
+// splitMark is an exact position along the track, possibly between two points
+type splitMark struct {
+	distance  float64
+	time      *time.Time
+	elevation *float64
+}
+
+// GetSplits divides a track into consecutive intervalMeters-long splits, ending with a
+// shorter final split for any remainder. Split boundaries are interpolated between the
+// two points straddling them, so every full split covers exactly intervalMeters.
+func (s *TrackService) GetSplits(ctx context.Context, id uint, intervalMeters float64) ([]Split, error) {
+	var track models.GPXTrack
+	if err := s.db.WithContext(ctx).Select("id").First(&track, id).Error; err != nil {
+		return nil, err
+	}
+
+	var points []models.TrackPoint
+	if err := s.db.WithContext(ctx).Where("track_id = ?", id).Order("id").Find(&points).Error; err != nil {
+		return nil, err
+	}
+
+	return computeSplits(points, intervalMeters), nil
+}
+
+// computeSplits walks the points accumulating haversine distance and emits a split each
+// time the cumulative distance crosses a multiple of intervalMeters
+func computeSplits(points []models.TrackPoint, intervalMeters float64) []Split {
+	splits := []Split{}
+	if len(points) < 2 || intervalMeters <= 0 {
+		return splits
+	}
+
+	start := splitMark{time: points[0].Time, elevation: points[0].Elevation}
+	boundary := intervalMeters
+	var cumulative float64
+
+	for i := 1; i < len(points); i++ {
+		prev, point := points[i-1], points[i]
+		segment := haversineDistance(prev.Latitude, prev.Longitude, point.Latitude, point.Longitude)
+
+		// A long segment can cross several boundaries
+		for segment > 0 && cumulative+segment >= boundary {
+			fraction := (boundary - cumulative) / segment
+			end := splitMark{
+				distance:  boundary,
+				time:      interpolateTime(prev.Time, point.Time, fraction),
+				elevation: interpolateElevation(prev.Elevation, point.Elevation, fraction),
+			}
+			splits = append(splits, newSplit(len(splits)+1, start, end))
+			start = end
+			boundary += intervalMeters
+		}
+
+		cumulative += segment
+	}
+
+	// Final partial split, skipping float noise left after an exact boundary
+	if cumulative-start.distance > 0.01 {
+		last := points[len(points)-1]
+		end := splitMark{distance: cumulative, time: last.Time, elevation: last.Elevation}
+		splits = append(splits, newSplit(len(splits)+1, start, end))
+	}
+
+	return splits
+}
+
+// newSplit builds the split between two marks
+func newSplit(index int, start, end splitMark) Split {
+	split := Split{
+		Index:          index,
+		DistanceMeters: end.distance - start.distance,
+	}
+
+	if start.time != nil && end.time != nil {
+		elapsed := end.time.Sub(*start.time).Seconds()
+		split.ElapsedSeconds = &elapsed
+		if split.DistanceMeters > 0 {
+			pace := elapsed / (split.DistanceMeters / 1000)
+			split.PaceSecondsPerKm = &pace
+		}
+	}
+
+	if start.elevation != nil && end.elevation != nil {
+		change := *end.elevation - *start.elevation
+		split.ElevationChange = &change
+	}
+
+	return split
+}
+
+// interpolateTime returns the time at fraction of the way from a to b, or nil unless both
+// are known
+func interpolateTime(a, b *time.Time, fraction float64) *time.Time {
+	if a == nil || b == nil {
+		return nil
+	}
+	t := a.Add(time.Duration(float64(b.Sub(*a)) * fraction))
+	return &t
+}
+
+// interpolateElevation returns the elevation at fraction of the way from a to b, falling
+// back to whichever one is known
+func interpolateElevation(a, b *float64, fraction float64) *float64 {
+	switch {
+	case a != nil && b != nil:
+		elevation := *a + (*b-*a)*fraction
+		return &elevation
+	case a != nil:
+		return a
+	default:
+		return b
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mytracks-api/models"
+)
+
+// defaultStatsCacheTTL is how long dataset totals are reused before being recomputed
+const defaultStatsCacheTTL = 60 * time.Second
+
+// DatasetStats summarizes every track in the database
+type DatasetStats struct {
+	TrackCount         int64      `json:"track_count"`
+	TotalDistance      float64    `json:"total_distance"`       // in meters
+	TotalElevationGain float64    `json:"total_elevation_gain"` // in meters
+	TotalDuration      int64      `json:"total_duration"`       // in seconds
+	EarliestStart      *time.Time `json:"earliest_start"`
+	LatestEnd          *time.Time `json:"latest_end"`
+}
+
+// statsCache holds the last computed DatasetStats. Unlike boundsCache it isn't cleared on
+// writes: seeding inserts constantly, and slightly stale totals are fine for a dashboard.
+type statsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	stats   *DatasetStats
+	expires time.Time
+}
+
+// GetStats returns totals across all tracks, computed with SQL aggregates and cached for
+// STATS_CACHE_TTL_SECONDS
+func (s *TrackService) GetStats(ctx context.Context) (*DatasetStats, error) {
+	cache := s.statsCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.stats != nil && time.Now().Before(cache.expires) {
+		return cache.stats, nil
+	}
+
+	var stats DatasetStats
+	err := s.db.WithContext(ctx).Model(&models.GPXTrack{}).
+		Select(`COUNT(*) AS track_count,
+			COALESCE(SUM(distance), 0) AS total_distance,
+			COALESCE(SUM(elevation_gain), 0) AS total_elevation_gain,
+			COALESCE(SUM(duration), 0) AS total_duration,
+			MIN(start_time) AS earliest_start,
+			MAX(end_time) AS latest_end`).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	cache.stats = &stats
+	cache.expires = time.Now().Add(cache.ttl)
+	return &stats, nil
+}
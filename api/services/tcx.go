@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mytracks-api/models"
+)
+
+const tcxTimeFormat = "2006-01-02T15:04:05Z"
+
+// GetTCXData returns the track as a Garmin TCX document along with a download filename
+func (s *TrackService) GetTCXData(ctx context.Context, id uint) ([]byte, string, error) {
+	// Get track with all points
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("TrackPoints").First(&track, id).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	tcx := s.GenerateTCX(track)
+	filename := fmt.Sprintf("track_%d.tcx", id)
+	if track.Filename != "" {
+		filename = strings.TrimSuffix(track.Filename, filepath.Ext(track.Filename)) + ".tcx"
+	}
+
+	return []byte(tcx), filename, nil
+}
+
+// GenerateTCX builds a TrainingCenterDatabase v2 document with a single activity and lap.
+// Points without timestamps get one synthesized from StartTime spread evenly over Duration;
+// when the track has no StartTime at all, the Time element is omitted.
+func (s *TrackService) GenerateTCX(track models.GPXTrack) string {
+	var tcx strings.Builder
+
+	// Activity and lap start times are required by the schema
+	activityStart := track.CreatedAt
+	if track.StartTime != nil {
+		activityStart = *track.StartTime
+	}
+
+	tcx.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	tcx.WriteString(`<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">`)
+	tcx.WriteString(`<Activities>`)
+	tcx.WriteString(fmt.Sprintf(`<Activity Sport="%s">`, tcxSport(track.Type)))
+	tcx.WriteString(fmt.Sprintf(`<Id>%s</Id>`, activityStart.UTC().Format(tcxTimeFormat)))
+
+	// Single lap covering the whole track
+	tcx.WriteString(fmt.Sprintf(`<Lap StartTime="%s">`, activityStart.UTC().Format(tcxTimeFormat)))
+	tcx.WriteString(fmt.Sprintf(`<TotalTimeSeconds>%d</TotalTimeSeconds>`, track.Duration))
+	tcx.WriteString(fmt.Sprintf(`<DistanceMeters>%.2f</DistanceMeters>`, track.Distance))
+	tcx.WriteString(`<Intensity>Active</Intensity>`)
+	tcx.WriteString(`<TriggerMethod>Manual</TriggerMethod>`)
+	tcx.WriteString(`<Track>`)
+
+	// Spacing used to synthesize missing timestamps
+	var interval time.Duration
+	if len(track.TrackPoints) > 1 {
+		interval = time.Duration(track.Duration) * time.Second / time.Duration(len(track.TrackPoints)-1)
+	}
+
+	var cumulativeDistance float64
+	for i, point := range track.TrackPoints {
+		if i > 0 {
+			prev := track.TrackPoints[i-1]
+			cumulativeDistance += haversineDistance(prev.Latitude, prev.Longitude, point.Latitude, point.Longitude)
+		}
+
+		tcx.WriteString(`<Trackpoint>`)
+
+		if point.Time != nil {
+			tcx.WriteString(fmt.Sprintf(`<Time>%s</Time>`, point.Time.UTC().Format(tcxTimeFormat)))
+		} else if track.StartTime != nil {
+			synthesized := track.StartTime.Add(time.Duration(i) * interval)
+			tcx.WriteString(fmt.Sprintf(`<Time>%s</Time>`, synthesized.UTC().Format(tcxTimeFormat)))
+		}
+
+		tcx.WriteString(`<Position>`)
+		tcx.WriteString(fmt.Sprintf(`<LatitudeDegrees>%.6f</LatitudeDegrees>`, point.Latitude))
+		tcx.WriteString(fmt.Sprintf(`<LongitudeDegrees>%.6f</LongitudeDegrees>`, point.Longitude))
+		tcx.WriteString(`</Position>`)
+
+		if point.Elevation != nil {
+			tcx.WriteString(fmt.Sprintf(`<AltitudeMeters>%.2f</AltitudeMeters>`, *point.Elevation))
+		}
+
+		tcx.WriteString(fmt.Sprintf(`<DistanceMeters>%.2f</DistanceMeters>`, cumulativeDistance))
+		tcx.WriteString(`</Trackpoint>`)
+	}
+
+	tcx.WriteString(`</Track>`)
+	tcx.WriteString(`</Lap>`)
+
+	if track.Name != "" {
+		tcx.WriteString(fmt.Sprintf(`<Notes>%s</Notes>`, xmlEscape(track.Name)))
+	}
+
+	tcx.WriteString(`</Activity>`)
+	tcx.WriteString(`</Activities>`)
+	tcx.WriteString(`</TrainingCenterDatabase>`)
+
+	return tcx.String()
+}
+
+// tcxSport maps a GPX track type onto one of the sports allowed by the TCX schema
+func tcxSport(trackType *string) string {
+	if trackType == nil {
+		return "Other"
+	}
+
+	switch t := strings.ToLower(*trackType); {
+	case strings.Contains(t, "run"):
+		return "Running"
+	case strings.Contains(t, "bik"), strings.Contains(t, "cycl"):
+		return "Biking"
+	default:
+		return "Other"
+	}
+}
@@ -1,34 +1,131 @@
 package services
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
+	"mytracks-api/config"
 	"mytracks-api/models"
 
 	"github.com/mmcloughlin/geohash"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// gpxPointBatchSize is how many track points are read per query when streaming GPX
+const gpxPointBatchSize = 5000
+
+// Supported GPX output versions
+const (
+	GPXVersion10 = "1.0"
+	GPXVersion11 = "1.1"
+)
+
+// Decimal places of exported GPX coordinates. Six is about 0.1 m, nine about 0.1 mm.
+const (
+	DefaultGPXPrecision = 6
+	MinGPXPrecision     = 4
+	MaxGPXPrecision     = 9
+)
+
+// defaultPointBatchSize is how many track points are inserted per statement when creating a track
+const defaultPointBatchSize = 1000
+
+// ErrInvalidSort is returned when a track listing is requested with an unknown sort key
+var ErrInvalidSort = errors.New("invalid sort key")
+
+// ErrPayloadTooLarge is returned when coordinates can't be simplified enough to fit a byte budget
+var ErrPayloadTooLarge = errors.New("coordinates cannot be reduced to fit the requested size")
+
 type TrackService struct {
-	db         *gorm.DB
-	gpxService *GPXService
-	gpxPath    string // Can be either a directory or tar.gz file
+	db             *gorm.DB
+	gpxService     *GPXService
+	gpxPath        string // Can be either a directory or tar.gz file
+	pointBatchSize int    // Track points per INSERT statement when creating tracks
+	maxTrackPoints int    // Most points GetTrackByID loads; 0 means no limit
+	usePostGIS     bool   // Maintain route geometries and use them for intersection queries
+	boundsCache    *boundsCache
+	statsCache     *statsCache
 }
 
 func NewTrackService(db *gorm.DB, gpxPath string) *TrackService {
 	return &TrackService{
-		db:         db,
-		gpxService: NewGPXService(),
-		gpxPath:    gpxPath,
+		db:             db,
+		gpxService:     NewGPXService(),
+		gpxPath:        gpxPath,
+		pointBatchSize: config.Int("TRACK_POINT_BATCH_SIZE", defaultPointBatchSize),
+		maxTrackPoints: config.Int("MAX_TRACK_POINTS", 0),
+		usePostGIS:     config.Bool("USE_POSTGIS", false),
+		boundsCache:    newBoundsCache(time.Duration(config.Int("BOUNDS_CACHE_TTL_SECONDS", int(defaultBoundsCacheTTL/time.Second))) * time.Second),
+		statsCache:     &statsCache{ttl: time.Duration(config.Int("STATS_CACHE_TTL_SECONDS", int(defaultStatsCacheTTL/time.Second))) * time.Second},
 	}
 }
 
-func (s *TrackService) GetTracks(query string, minDistance, maxDistance *float64, minDuration, maxDuration *int) ([]models.GPXTrack, error) {
+// CreateTrackWithPoints inserts a track and its points in a single transaction
+func (s *TrackService) CreateTrackWithPoints(track *models.GPXTrack) error {
+	defer s.boundsCache.invalidate()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.createTrackWithPoints(tx, track)
+	})
+}
+
+// CreateTracksWithPoints inserts several tracks and their points in a single transaction
+func (s *TrackService) CreateTracksWithPoints(tracks []*models.GPXTrack) error {
+	defer s.boundsCache.invalidate()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, track := range tracks {
+			if err := s.createTrackWithPoints(tx, track); err != nil {
+				return fmt.Errorf("track %s: %w", track.Filename, err)
+			}
+		}
+		return nil
+	})
+}
+
+// createTrackWithPoints inserts the track row and waypoints, then its points with
+// CreateInBatches rather than letting GORM save the association in one huge statement
+func (s *TrackService) createTrackWithPoints(tx *gorm.DB, track *models.GPXTrack) error {
+	if err := tx.Omit("TrackPoints").Create(track).Error; err != nil {
+		return err
+	}
+
+	points := track.TrackPoints
+	if len(points) > 0 {
+		for i := range points {
+			points[i].TrackID = track.ID
+		}
+		batchSize := s.pointBatchSize
+		if batchSize < 1 {
+			batchSize = defaultPointBatchSize
+		}
+		if err := tx.CreateInBatches(points, batchSize).Error; err != nil {
+			return fmt.Errorf("failed to insert track points: %w", err)
+		}
+	}
+
+	if s.usePostGIS {
+		if err := setTrackRoute(tx, track.ID); err != nil {
+			return fmt.Errorf("failed to build route geometry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *TrackService) GetTracks(ctx context.Context, query string, minDistance, maxDistance *float64, minDuration, maxDuration *int) ([]models.GPXTrack, error) {
 	var tracks []models.GPXTrack
 
 	// Don't preload track points by default - too much data for list view
-	db := s.db.Model(&models.GPXTrack{})
+	db := s.db.WithContext(ctx).Model(&models.GPXTrack{})
 
 	// Apply search filters
 	if query != "" {
@@ -57,140 +154,437 @@ func (s *TrackService) GetTracks(query string, minDistance, maxDistance *float64
 	return tracks, err
 }
 
+// TrackFilters holds the optional filters for GetTracksWithLocation. Nil fields are not applied.
+type TrackFilters struct {
+	Query             string
+	North             *float64
+	South             *float64
+	East              *float64
+	West              *float64
+	MinDistance       *float64
+	MaxDistance       *float64
+	MinDuration       *int
+	MaxDuration       *int
+	EstimatedDuration *int // in hours, matched ±1 hour
+	MinMovingTime     *int
+	MaxMovingTime     *int
+	MinPoints         *int
+	MaxPoints         *int
+	MinAreaKm2        *float64
+	MaxAreaKm2        *float64
+	StartDate         *time.Time // inclusive, matched against StartTime or CreatedAt
+	EndDate           *time.Time // exclusive
+	Sort              string     // key of trackSortColumns, or "relevance" with Query; defaults to created_at
+	Descending        bool
+	IncludeDeleted    bool // include soft-deleted tracks
+	// HasElevation and HasTime keep only tracks with (true) or without (false) elevation
+	// data or timestamps. Tracks without timestamps have zero duration and moving time, so
+	// the duration filters already exclude them; has_time=false combined with a minimum
+	// duration matches nothing.
+	HasElevation *bool
+	HasTime      *bool
+	Region       string // case-insensitive exact match
+	// Cursor starts the listing after this position; only valid with the created_at sort
+	Cursor *TrackCursor
+}
+
+// minFullTextQueryLength is the shortest query matched with full-text search; shorter
+// queries are usually word prefixes, which substring matching handles better
+const minFullTextQueryLength = 3
+
+// trackSortColumns whitelists the sort keys accepted by GetTracksWithLocation and maps them
+// onto columns, so user input never reaches the ORDER BY clause directly
+var trackSortColumns = map[string]string{
+	"created_at": "created_at",
+	"distance":   "distance",
+	"duration":   "duration",
+	"name":       "LOWER(name)",
+	"start_time": "start_time",
+}
+
 // GetTracksWithLocation returns tracks with optional geographic filtering using geohash optimization
-func (s *TrackService) GetTracksWithLocation(query string, north, south, east, west *float64, minDistance, maxDistance *float64, minDuration, maxDuration *int, estimatedDuration *int, limit int, includeRoutes bool) ([]models.GPXTrack, error) {
+func (s *TrackService) GetTracksWithLocation(ctx context.Context, filters TrackFilters, limit int, includeRoutes bool) ([]models.GPXTrack, error) {
+	db, err := s.tracksQuery(ctx, filters, limit, includeRoutes)
+	if err != nil {
+		return nil, err
+	}
+
 	var tracks []models.GPXTrack
+	err = db.Find(&tracks).Error
+	return tracks, err
+}
+
+// StreamTracksWithLocation runs the same query as GetTracksWithLocation but hands each
+// track to fn as its row is read, so the result set is never held in memory at once.
+// Points aren't loaded. An error from fn stops the iteration and is returned.
+func (s *TrackService) StreamTracksWithLocation(ctx context.Context, filters TrackFilters, limit int, fn func(*models.GPXTrack) error) error {
+	db, err := s.tracksQuery(ctx, filters, limit, false)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var track models.GPXTrack
+		if err := db.ScanRows(rows, &track); err != nil {
+			return err
+		}
+		if err := fn(&track); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// tracksQuery builds the filtered, ordered and limited track listing query
+func (s *TrackService) tracksQuery(ctx context.Context, filters TrackFilters, limit int, includeRoutes bool) (*gorm.DB, error) {
+	sortKey := filters.Sort
+	if sortKey == "" {
+		sortKey = "created_at"
+	}
+	sortColumn, ok := trackSortColumns[sortKey]
+	if !ok && sortKey != "relevance" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, sortKey)
+	}
 
 	// Optionally preload track points for route display
-	db := s.db.Model(&models.GPXTrack{})
+	db := s.db.WithContext(ctx).Model(&models.GPXTrack{})
+	if filters.IncludeDeleted {
+		db = db.Unscoped()
+	}
 	if includeRoutes {
 		db = db.Preload("TrackPoints")
 	}
 
 	// Apply geographic filtering if bounds are provided
-	if north != nil && south != nil && east != nil && west != nil {
-		// Calculate geohashes for the corners of the search bounds
-		topLeftHash := geohash.Encode(*north, *west)
-		bottomRightHash := geohash.Encode(*south, *east)
+	if filters.North != nil && filters.South != nil && filters.East != nil && filters.West != nil {
+		north, south, east, west := *filters.North, *filters.South, *filters.East, *filters.West
 
-		// Find the common prefix of the corner geohashes
-		commonPrefix := findCommonPrefix(topLeftHash, bottomRightHash)
-
-		// Use geohash prefix matching for initial filtering (much faster)
-		if len(commonPrefix) > 0 {
-			db = db.Where("geohash LIKE ?", commonPrefix+"%")
+		// Use geohash cell matching for initial filtering (much faster)
+		if cells := geohashCover(north, south, east, west); len(cells) > 0 {
+			clause, args := geohashCoverClause(cells)
+			db = db.Where(clause, args...)
 		}
 
 		// Apply precise bounds checking
-		db = db.Where(
-			"north >= ? AND south <= ? AND east >= ? AND west <= ?",
-			*south, *north, *west, *east,
-		)
+		clause, args := boundsClause(north, south, east, west)
+		db = db.Where(clause, args...)
 	}
 
-	// Apply text search filters
-	if query != "" {
-		searchPattern := "%" + strings.ToLower(query) + "%"
+	// Apply text search filters: full-text search on the indexed search_vector, with
+	// substring matching for very short queries
+	fullText := len(strings.TrimSpace(filters.Query)) >= minFullTextQueryLength
+	if fullText {
+		db = db.Where("search_vector @@ plainto_tsquery('english', ?)", filters.Query)
+	} else if filters.Query != "" {
+		searchPattern := "%" + strings.ToLower(filters.Query) + "%"
 		db = db.Where("LOWER(name) LIKE ? OR LOWER(filename) LIKE ? OR LOWER(description) LIKE ?",
 			searchPattern, searchPattern, searchPattern)
 	}
 
 	// Apply distance filters
-	if minDistance != nil {
-		db = db.Where("distance >= ?", *minDistance)
+	if filters.MinDistance != nil {
+		db = db.Where("distance >= ?", *filters.MinDistance)
 	}
-	if maxDistance != nil {
-		db = db.Where("distance <= ?", *maxDistance)
+	if filters.MaxDistance != nil {
+		db = db.Where("distance <= ?", *filters.MaxDistance)
 	}
 
 	// Apply duration filters
-	if minDuration != nil {
-		db = db.Where("duration >= ?", *minDuration)
+	if filters.MinDuration != nil {
+		db = db.Where("duration >= ?", *filters.MinDuration)
 	}
-	if maxDuration != nil {
-		db = db.Where("duration <= ?", *maxDuration)
+	if filters.MaxDuration != nil {
+		db = db.Where("duration <= ?", *filters.MaxDuration)
+	}
+
+	// Apply moving time filters
+	if filters.MinMovingTime != nil {
+		db = db.Where("moving_time >= ?", *filters.MinMovingTime)
+	}
+	if filters.MaxMovingTime != nil {
+		db = db.Where("moving_time <= ?", *filters.MaxMovingTime)
 	}
 
 	// Apply estimated duration filter (±1 hour)
-	if estimatedDuration != nil {
+	if filters.EstimatedDuration != nil {
 		// Convert estimated duration from hours to seconds
-		estimatedSeconds := *estimatedDuration * 3600
+		estimatedSeconds := *filters.EstimatedDuration * 3600
 		// Add/subtract 1 hour (3600 seconds)
 		minEstDuration := estimatedSeconds - 3600
 		maxEstDuration := estimatedSeconds + 3600
 		db = db.Where("duration >= ? AND duration <= ?", minEstDuration, maxEstDuration)
 	}
 
-	// Order by creation date (newest first) and apply limit
-	err := db.Order("created_at DESC").Limit(limit).Find(&tracks).Error
-	return tracks, err
+	// Apply point count filters
+	if filters.MinPoints != nil {
+		db = db.Where("point_count >= ?", *filters.MinPoints)
+	}
+	if filters.MaxPoints != nil {
+		db = db.Where("point_count <= ?", *filters.MaxPoints)
+	}
+
+	// Apply bounding box area filters
+	if filters.MinAreaKm2 != nil {
+		db = db.Where("bounding_box_area_km2 >= ?", *filters.MinAreaKm2)
+	}
+	if filters.MaxAreaKm2 != nil {
+		db = db.Where("bounding_box_area_km2 <= ?", *filters.MaxAreaKm2)
+	}
+
+	// Apply region filter
+	if filters.Region != "" {
+		db = db.Where("LOWER(region) = LOWER(?)", filters.Region)
+	}
+
+	// Apply data availability filters. Flat files still get a constant MinElevation and
+	// MaxElevation of zero, so an unchanging elevation counts as missing.
+	if filters.HasElevation != nil {
+		if *filters.HasElevation {
+			db = db.Where("elevation_gain > 0 OR max_elevation <> min_elevation")
+		} else {
+			db = db.Where("elevation_gain = 0 AND max_elevation = min_elevation")
+		}
+	}
+	if filters.HasTime != nil {
+		if *filters.HasTime {
+			db = db.Where("start_time IS NOT NULL")
+		} else {
+			db = db.Where("start_time IS NULL")
+		}
+	}
+
+	// Apply date range filters, falling back to the import date for tracks without timestamps
+	if filters.StartDate != nil {
+		db = db.Where("COALESCE(start_time, created_at) >= ?", *filters.StartDate)
+	}
+	if filters.EndDate != nil {
+		db = db.Where("COALESCE(start_time, created_at) < ?", *filters.EndDate)
+	}
+
+	// Keyset pagination: continue after the cursor in the listing's direction
+	if filters.Cursor != nil {
+		if sortKey != "created_at" {
+			return nil, fmt.Errorf("%w: cursors need sort=created_at", ErrInvalidCursor)
+		}
+		comparison := ">"
+		if filters.Descending {
+			comparison = "<"
+		}
+		db = db.Where("(created_at, id) "+comparison+" (?, ?)", filters.Cursor.CreatedAt, filters.Cursor.ID)
+	}
+
+	// Relevance ranks full-text matches best first; without a full-text query there is
+	// nothing to rank, so it falls back to newest first
+	if sortKey == "relevance" {
+		if fullText {
+			db = db.Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL:  "ts_rank(search_vector, plainto_tsquery('english', ?)) DESC, id DESC",
+				Vars: []interface{}{filters.Query},
+			}})
+			return db.Limit(limit), nil
+		}
+		sortColumn, filters.Descending = "created_at", true
+	}
+
+	// Order by the requested column, with id as a tiebreaker, and apply limit.
+	// Tracks without a start time always sort last.
+	direction := "ASC"
+	if filters.Descending {
+		direction = "DESC"
+	}
+	order := fmt.Sprintf("%s %s NULLS LAST, id %s", sortColumn, direction, direction)
+	return db.Order(order).Limit(limit), nil
 }
 
-func (s *TrackService) GetTrackByID(id uint) (*models.GPXTrack, error) {
+// GetTrackByID returns a track with its waypoints and points in recorded order. Only the
+// given track_points columns are read; the key columns the preload needs are added. With
+// MAX_TRACK_POINTS set, only that many leading points are loaded, so one huge track
+// stored before the limit existed can't exhaust memory; PointCount still has the total.
+func (s *TrackService) GetTrackByID(ctx context.Context, id uint, pointColumns []string) (*models.GPXTrack, error) {
+	columns := []string{"id", "track_id"}
+	for _, column := range pointColumns {
+		if column != "id" && column != "track_id" {
+			columns = append(columns, column)
+		}
+	}
+
 	var track models.GPXTrack
-	err := s.db.Preload("TrackPoints").First(&track, id).Error
+	err := s.db.WithContext(ctx).
+		Preload("TrackPoints", func(db *gorm.DB) *gorm.DB {
+			db = db.Select(columns).Order("id")
+			if s.maxTrackPoints > 0 {
+				db = db.Limit(s.maxTrackPoints)
+			}
+			return db
+		}).
+		Preload("Waypoints").
+		First(&track, id).Error
 	if err != nil {
 		return nil, err
 	}
 	return &track, nil
 }
 
-func (s *TrackService) GetTracksByBounds(north, south, east, west float64, limit int) ([]models.GPXTrack, error) {
-	var tracks []models.GPXTrack
+// GetTrackSummary returns a track's metadata, stats and bounds without loading its points
+// or waypoints
+func (s *TrackService) GetTrackSummary(ctx context.Context, id uint) (*models.GPXTrack, error) {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).First(&track, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
 
-	// Calculate geohashes for the corners of the search bounds
-	topLeftHash := geohash.Encode(north, west)
-	bottomRightHash := geohash.Encode(south, east)
+// GetTrackPointsPaged returns up to limit points of a track in recorded order, starting at
+// offset, along with the track's total number of points
+func (s *TrackService) GetTrackPointsPaged(ctx context.Context, id uint, offset, limit int) ([]models.TrackPoint, int64, error) {
+	db := s.db.WithContext(ctx)
+	if err := db.Select("id").First(&models.GPXTrack{}, id).Error; err != nil {
+		return nil, 0, err
+	}
 
-	// Find the common prefix of the corner geohashes
-	// This gives us the geohash precision that covers the search area
-	commonPrefix := findCommonPrefix(topLeftHash, bottomRightHash)
+	var total int64
+	if err := db.Model(&models.TrackPoint{}).Where("track_id = ?", id).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 
-	// Use geohash prefix matching for initial filtering (much faster)
-	// Then apply precise bounds checking as a secondary filter
-	// DON'T preload track points for bounds queries - too much data
-	query := s.db.Model(&models.GPXTrack{})
+	points := []models.TrackPoint{}
+	err := db.Where("track_id = ?", id).Order("id").Offset(offset).Limit(limit).Find(&points).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, total, nil
+}
 
-	if len(commonPrefix) > 0 {
-		// Use geohash prefix for fast initial filtering
-		query = query.Where("geohash LIKE ?", commonPrefix+"%")
+// DeleteTrack soft-deletes a track so it can be restored later. Its points are kept.
+func (s *TrackService) DeleteTrack(ctx context.Context, id uint) error {
+	defer s.boundsCache.invalidate()
+	result := s.db.WithContext(ctx).Delete(&models.GPXTrack{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
+	return nil
+}
 
-	// Apply precise bounds checking
-	query = query.Where(
-		"north >= ? AND south <= ? AND east >= ? AND west <= ?",
-		south, north, west, east,
-	).Limit(limit).Order("created_at DESC")
+// RestoreTrack undoes a soft delete and returns the track without points loaded.
+// Restoring a track that isn't deleted is a no-op.
+func (s *TrackService) RestoreTrack(ctx context.Context, id uint) (*models.GPXTrack, error) {
+	defer s.boundsCache.invalidate()
+	var track models.GPXTrack
+	if err := s.db.WithContext(ctx).Unscoped().First(&track, id).Error; err != nil {
+		return nil, err
+	}
 
-	err := query.Find(&tracks).Error
-	return tracks, err
+	if track.DeletedAt.Valid {
+		if err := s.db.WithContext(ctx).Unscoped().Model(&track).Update("deleted_at", nil).Error; err != nil {
+			return nil, err
+		}
+		track.DeletedAt = gorm.DeletedAt{}
+	}
+
+	return &track, nil
 }
 
-// findCommonPrefix finds the longest common prefix between two geohashes
-func findCommonPrefix(hash1, hash2 string) string {
-	minLen := len(hash1)
-	if len(hash2) < minLen {
-		minLen = len(hash2)
+// UpdateTrackMetadata changes the user-editable fields of a track. Nil arguments are left
+// untouched; empty description or activity type values clear the field. Computed fields
+// such as distance or bounds can't be changed here. The returned track has no points loaded.
+func (s *TrackService) UpdateTrackMetadata(ctx context.Context, id uint, name, description, activityType *string) (*models.GPXTrack, error) {
+	defer s.boundsCache.invalidate()
+	var track models.GPXTrack
+	if err := s.db.WithContext(ctx).First(&track, id).Error; err != nil {
+		return nil, err
 	}
 
-	var prefix strings.Builder
-	for i := 0; i < minLen; i++ {
-		if hash1[i] == hash2[i] {
-			prefix.WriteByte(hash1[i])
-		} else {
-			break
+	updates := make(map[string]interface{})
+	if name != nil {
+		updates["name"] = *name
+	}
+	if description != nil {
+		updates["description"] = nullableString(*description)
+	}
+	if activityType != nil {
+		updates["type"] = nullableString(*activityType)
+	}
+
+	if len(updates) > 0 {
+		// Updates with a map also bumps updated_at
+		if err := s.db.WithContext(ctx).Model(&track).Updates(updates).Error; err != nil {
+			return nil, err
 		}
 	}
 
-	// Ensure we have a meaningful prefix (at least 2 characters for reasonable geographic area)
-	result := prefix.String()
-	if len(result) < 2 {
-		return ""
+	if err := s.db.WithContext(ctx).First(&track, id).Error; err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// nullableString maps an empty string to NULL for optional text columns
+func nullableString(value string) *string {
+	if value == "" {
+		return nil
 	}
+	return &value
+}
 
-	return result
+func (s *TrackService) GetTracksByBounds(ctx context.Context, north, south, east, west float64, limit int) ([]models.GPXTrack, error) {
+	// Panning maps repeat near-identical queries; serve those from the short-lived cache
+	cacheKey := newBoundsCacheKey(north, south, east, west, limit)
+	if cached, ok := s.boundsCache.get(cacheKey); ok {
+		return cached, nil
+	}
+
+	var tracks []models.GPXTrack
+
+	// Use geohash cell matching for initial filtering (much faster)
+	// Then apply precise bounds checking as a secondary filter
+	// DON'T preload track points for bounds queries - too much data
+	query := s.db.WithContext(ctx).Model(&models.GPXTrack{})
+
+	// The covering cells (plus neighbors) handle boxes that straddle cell boundaries
+	if cells := geohashCover(north, south, east, west); len(cells) > 0 {
+		clause, args := geohashCoverClause(cells)
+		query = query.Where(clause, args...)
+	}
+
+	// Apply precise bounds checking
+	clause, args := boundsClause(north, south, east, west)
+	query = query.Where(clause, args...).Limit(limit).Order("created_at DESC")
+
+	if err := query.Find(&tracks).Error; err != nil {
+		return nil, err
+	}
+
+	s.boundsCache.set(cacheKey, tracks)
+	return tracks, nil
+}
+
+// boundsClause builds the condition matching tracks whose bounds intersect the search box.
+// When the box crosses the antimeridian (east < west) the longitude test is split into
+// the two ranges west..180 and -180..east, combined with OR.
+func boundsClause(north, south, east, west float64) (string, []interface{}) {
+	if east < west {
+		return "north >= ? AND south <= ? AND (east >= ? OR west <= ?)",
+			[]interface{}{south, north, west, east}
+	}
+
+	return "north >= ? AND south <= ? AND east >= ? AND west <= ?",
+		[]interface{}{south, north, west, east}
 }
 
+// PopulateMissingGeohashes backfills the geohash of legacy rows stored before the parser
+// computed it. New tracks get their geohash in computeTrackStats before insertion, so on
+// an up-to-date database this finds nothing and returns immediately.
 func (s *TrackService) PopulateMissingGeohashes() {
 	log := fmt.Printf // Use fmt.Printf for logging in this goroutine
 
@@ -225,9 +619,7 @@ func (s *TrackService) PopulateMissingGeohashes() {
 
 		// Update each track in the batch
 		for _, track := range batch {
-			// Calculate centroid from bounds
-			centroidLat := (track.Bounds.North + track.Bounds.South) / 2
-			centroidLon := (track.Bounds.East + track.Bounds.West) / 2
+			centroidLat, centroidLon := centroidOf(track)
 
 			// Generate geohash
 			trackGeohash := geohash.Encode(centroidLat, centroidLon)
@@ -256,13 +648,20 @@ type TrackCoordinate struct {
 	Latitude  float64  `json:"latitude"`
 	Longitude float64  `json:"longitude"`
 	Elevation *float64 `json:"elevation"`
+	Bearing   *float64 `json:"bearing,omitempty"` // degrees from north, only set by AddBearings
 }
 
-func (s *TrackService) GetTrackCoordinates(trackIDs []uint) (map[uint][]TrackCoordinate, error) {
+// GetTrackCoordinates returns the points of each requested track. When tolerance (in meters)
+// is positive, each track is simplified with Ramer-Douglas-Peucker before being returned.
+func (s *TrackService) GetTrackCoordinates(ctx context.Context, trackIDs []uint, tolerance float64) (map[uint][]TrackCoordinate, error) {
 	var trackPoints []models.TrackPoint
 
 	// Query only the fields we need: track_id, latitude, longitude, elevation
-	err := s.db.Select("track_id, latitude, longitude, elevation").Where("track_id IN ?", trackIDs).Find(&trackPoints).Error
+	// Points must come back in recorded order for simplification to make sense. Points
+	// are kept on soft delete, so deleted tracks are filtered out here.
+	err := s.db.WithContext(ctx).Select("track_id, latitude, longitude, elevation").
+		Where("track_id IN (SELECT id FROM gpx_tracks WHERE id IN ? AND deleted_at IS NULL)", trackIDs).
+		Order("track_id, id").Find(&trackPoints).Error
 	if err != nil {
 		return nil, err
 	}
@@ -278,71 +677,315 @@ func (s *TrackService) GetTrackCoordinates(trackIDs []uint) (map[uint][]TrackCoo
 		result[point.TrackID] = append(result[point.TrackID], coord)
 	}
 
+	if tolerance > 0 {
+		for trackID, coords := range result {
+			result[trackID] = simplifyTrack(coords, tolerance)
+		}
+	}
+
 	return result, nil
 }
 
-func (s *TrackService) GetGPXData(id uint) ([]byte, string, error) {
-	// Get track with all points
+// EncodeTrackPolylines converts each track's coordinates into a Google-encoded polyline string
+func (s *TrackService) EncodeTrackPolylines(coordinates map[uint][]TrackCoordinate) map[uint]string {
+	result := make(map[uint]string, len(coordinates))
+	for trackID, points := range coordinates {
+		result[trackID] = encodePolyline(points)
+	}
+	return result
+}
+
+// FitTrackCoordinates simplifies the coordinates with an increasing tolerance until
+// the serialized JSON payload fits within maxBytes. It returns the fitted coordinates
+// together with the encoded payload so callers can send exactly what was measured.
+func (s *TrackService) FitTrackCoordinates(coordinates map[uint][]TrackCoordinate, maxBytes int) (map[uint][]TrackCoordinate, []byte, error) {
+	payload, err := json.Marshal(coordinates)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(payload) <= maxBytes {
+		return coordinates, payload, nil
+	}
+
+	// Double the tolerance each round, from 1 meter up to roughly 1000 km
+	for tolerance := 1.0; tolerance <= 1000000; tolerance *= 2 {
+		fitted := make(map[uint][]TrackCoordinate, len(coordinates))
+		for trackID, points := range coordinates {
+			fitted[trackID] = simplifyTrack(points, tolerance)
+			// Dropped points change each survivor's predecessor
+			if len(points) > 0 && points[0].Bearing != nil {
+				addTrackBearings(fitted[trackID])
+			}
+		}
+
+		payload, err = json.Marshal(fitted)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(payload) <= maxBytes {
+			return fitted, payload, nil
+		}
+	}
+
+	return nil, nil, ErrPayloadTooLarge
+}
+
+func (s *TrackService) GetGPXData(ctx context.Context, id uint, version string, precision int) ([]byte, string, error) {
+	// Get track with all points and waypoints
 	var track models.GPXTrack
-	err := s.db.Preload("TrackPoints").First(&track, id).Error
+	err := s.db.WithContext(ctx).Preload("TrackPoints").Preload("Waypoints").First(&track, id).Error
 	if err != nil {
 		return nil, "", err
 	}
 
 	// Generate GPX XML
-	gpxXML := s.generateGPX(track)
-	filename := track.Filename
-	if filename == "" {
-		filename = fmt.Sprintf("track_%d.gpx", id)
+	gpxXML := s.generateGPX(track, version, precision)
+
+	return []byte(gpxXML), gpxFilename(track), nil
+}
+
+// GetTrackUpdatedAt returns when a track was last modified, for HTTP caching of exports
+func (s *TrackService) GetTrackUpdatedAt(ctx context.Context, id uint) (time.Time, error) {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Select("id, updated_at").First(&track, id).Error
+	if err != nil {
+		return time.Time{}, err
 	}
+	return track.UpdatedAt, nil
+}
 
-	return []byte(gpxXML), filename, nil
+// TrackBounds is a track's bounding box and centroid, enough to fit a map view to it
+type TrackBounds struct {
+	ID          uint          `json:"id"`
+	Bounds      models.Bounds `json:"bounds"`
+	CentroidLat float64       `json:"centroid_lat"`
+	CentroidLon float64       `json:"centroid_lon"`
 }
 
-func (s *TrackService) generateGPX(track models.GPXTrack) string {
-	var gpx strings.Builder
+// GetTrackBounds returns a track's bounds and centroid, selecting only those columns
+func (s *TrackService) GetTrackBounds(ctx context.Context, id uint) (*TrackBounds, error) {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Select("id, north, south, east, west, centroid_lat, centroid_lon").First(&track, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &TrackBounds{
+		ID:          track.ID,
+		Bounds:      track.Bounds,
+		CentroidLat: track.CentroidLat,
+		CentroidLon: track.CentroidLon,
+	}, nil
+}
 
-	gpx.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
-	gpx.WriteString(`<gpx version="1.1" creator="MyTracks" xmlns="http://www.topografix.com/GPX/1/1">`)
+// GetGPXFilename returns the download filename for a track without loading its points
+func (s *TrackService) GetGPXFilename(ctx context.Context, id uint) (string, error) {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Select("id, filename").First(&track, id).Error
+	if err != nil {
+		return "", err
+	}
+	return gpxFilename(track), nil
+}
 
-	// Track metadata
-	if track.Name != "" {
-		gpx.WriteString(fmt.Sprintf(`<name>%s</name>`, track.Name))
+// GetGPXFilenames returns the download filenames for the given tracks, keyed by ID.
+// IDs that don't exist are left out.
+func (s *TrackService) GetGPXFilenames(ctx context.Context, ids []uint) (map[uint]string, error) {
+	var tracks []models.GPXTrack
+	err := s.db.WithContext(ctx).Select("id, filename").Where("id IN ?", ids).Find(&tracks).Error
+	if err != nil {
+		return nil, err
 	}
-	if track.Description != nil && *track.Description != "" {
-		gpx.WriteString(fmt.Sprintf(`<desc>%s</desc>`, *track.Description))
+
+	filenames := make(map[uint]string, len(tracks))
+	for _, track := range tracks {
+		filenames[track.ID] = gpxFilename(track)
 	}
+	return filenames, nil
+}
 
-	// Track segment
-	gpx.WriteString(`<trk>`)
-	if track.Name != "" {
-		gpx.WriteString(fmt.Sprintf(`<name>%s</name>`, track.Name))
+// WriteGPXZip streams a zip archive to w with one GPX entry per track, in the order of ids.
+// Only tracks present in filenames (see GetGPXFilenames) are included, and duplicate
+// filenames are prefixed with the track ID so entries don't collide.
+func (s *TrackService) WriteGPXZip(ctx context.Context, w io.Writer, ids []uint, filenames map[uint]string) error {
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool, len(filenames))
+
+	for _, id := range ids {
+		name, ok := filenames[id]
+		if !ok {
+			continue
+		}
+		if used[name] {
+			name = fmt.Sprintf("%d_%s", id, name)
+			if used[name] {
+				// Same ID requested twice
+				continue
+			}
+		}
+		used[name] = true
+
+		entry, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := s.WriteGPX(ctx, entry, id, GPXVersion11, DefaultGPXPrecision); err != nil {
+			return fmt.Errorf("track %d: %w", id, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// WriteGPX streams the GPX document for a track to w. Track points are read from the
+// database in batches rather than preloaded, so memory use stays flat for huge tracks.
+// Nothing is written if the track can't be loaded. version is GPXVersion10 or GPXVersion11;
+// precision is the number of decimals of coordinates, from MinGPXPrecision to MaxGPXPrecision.
+func (s *TrackService) WriteGPX(ctx context.Context, w io.Writer, id uint, version string, precision int) error {
+	var track models.GPXTrack
+	err := s.db.WithContext(ctx).Preload("Waypoints").First(&track, id).Error
+	if err != nil {
+		return err
+	}
+
+	// bufio keeps the first write error, which Flush reports at the end
+	writer := bufio.NewWriter(w)
+	writeGPXStart(writer, track, version, precision)
+
+	var batch []models.TrackPoint
+	err = s.db.WithContext(ctx).Where("track_id = ?", id).FindInBatches(&batch, gpxPointBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, point := range batch {
+			writeGPXTrackPoint(writer, point, precision)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
 	}
 
-	gpx.WriteString(`<trkseg>`)
+	writeGPXEnd(writer)
+	return writer.Flush()
+}
+
+func (s *TrackService) generateGPX(track models.GPXTrack, version string, precision int) string {
+	var gpx strings.Builder
+
+	writeGPXStart(&gpx, track, version, precision)
 
 	// Add track points
 	for _, point := range track.TrackPoints {
-		gpx.WriteString(`<trkpt lat="`)
-		gpx.WriteString(fmt.Sprintf("%.6f", point.Latitude))
-		gpx.WriteString(`" lon="`)
-		gpx.WriteString(fmt.Sprintf("%.6f", point.Longitude))
-		gpx.WriteString(`">`)
+		writeGPXTrackPoint(&gpx, point, precision)
+	}
+
+	writeGPXEnd(&gpx)
+
+	return gpx.String()
+}
+
+// gpxFilename returns the stored filename, or a generated one when it's missing
+func gpxFilename(track models.GPXTrack) string {
+	if track.Filename == "" {
+		return fmt.Sprintf("track_%d.gpx", track.ID)
+	}
+	return track.Filename
+}
+
+// writeGPXStart writes the document header, metadata and waypoints, and opens the track segment.
+// GPX 1.0 puts name, desc, author and time directly under <gpx>; 1.1 moves them into <metadata>.
+// The original creator is preserved when the track was imported with one. Waypoint
+// coordinates get precision decimals, like track points.
+func writeGPXStart(w io.Writer, track models.GPXTrack, version string, precision int) {
+	creator := "MyTracks"
+	if track.Creator != nil && *track.Creator != "" {
+		creator = *track.Creator
+	}
 
-		if point.Elevation != nil {
-			gpx.WriteString(fmt.Sprintf(`<ele>%.2f</ele>`, *point.Elevation))
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	if version == GPXVersion10 {
+		fmt.Fprintf(w, `<gpx version="1.0" creator="%s" xmlns="http://www.topografix.com/GPX/1/0">`, xmlEscape(creator))
+	} else {
+		fmt.Fprintf(w, `<gpx version="1.1" creator="%s" xmlns="http://www.topografix.com/GPX/1/1">`, xmlEscape(creator))
+	}
+
+	// Track metadata
+	hasDescription := track.Description != nil && *track.Description != ""
+	hasAuthor := track.Author != nil && *track.Author != ""
+	hasMetadata := track.Name != "" || hasDescription || hasAuthor || track.MetadataTime != nil
+	if version != GPXVersion10 && hasMetadata {
+		io.WriteString(w, `<metadata>`)
+	}
+	if track.Name != "" {
+		fmt.Fprintf(w, `<name>%s</name>`, xmlEscape(track.Name))
+	}
+	if hasDescription {
+		fmt.Fprintf(w, `<desc>%s</desc>`, xmlEscape(*track.Description))
+	}
+	if hasAuthor {
+		if version == GPXVersion10 {
+			fmt.Fprintf(w, `<author>%s</author>`, xmlEscape(*track.Author))
+		} else {
+			fmt.Fprintf(w, `<author><name>%s</name></author>`, xmlEscape(*track.Author))
 		}
+	}
+	if track.MetadataTime != nil {
+		fmt.Fprintf(w, `<time>%s</time>`, track.MetadataTime.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	if version != GPXVersion10 && hasMetadata {
+		io.WriteString(w, `</metadata>`)
+	}
 
-		if point.Time != nil {
-			gpx.WriteString(fmt.Sprintf(`<time>%s</time>`, point.Time.Format("2006-01-02T15:04:05Z")))
+	// Waypoints must precede tracks in the GPX schema
+	for _, waypoint := range track.Waypoints {
+		fmt.Fprintf(w, `<wpt lat="%.*f" lon="%.*f">`, precision, waypoint.Latitude, precision, waypoint.Longitude)
+		if waypoint.Elevation != nil {
+			fmt.Fprintf(w, `<ele>%.2f</ele>`, *waypoint.Elevation)
+		}
+		if waypoint.Name != "" {
+			fmt.Fprintf(w, `<name>%s</name>`, xmlEscape(waypoint.Name))
 		}
+		if waypoint.Description != nil && *waypoint.Description != "" {
+			fmt.Fprintf(w, `<desc>%s</desc>`, xmlEscape(*waypoint.Description))
+		}
+		if waypoint.Symbol != nil && *waypoint.Symbol != "" {
+			fmt.Fprintf(w, `<sym>%s</sym>`, xmlEscape(*waypoint.Symbol))
+		}
+		io.WriteString(w, `</wpt>`)
+	}
 
-		gpx.WriteString(`</trkpt>`)
+	// Track segment
+	io.WriteString(w, `<trk>`)
+	if track.Name != "" {
+		fmt.Fprintf(w, `<name>%s</name>`, xmlEscape(track.Name))
 	}
 
-	gpx.WriteString(`</trkseg>`)
-	gpx.WriteString(`</trk>`)
-	gpx.WriteString(`</gpx>`)
+	io.WriteString(w, `<trkseg>`)
+}
+
+// writeGPXTrackPoint writes a single <trkpt> element with precision decimals of latitude
+// and longitude. Elevation keeps two decimals, as centimeters are beyond GPS accuracy.
+func writeGPXTrackPoint(w io.Writer, point models.TrackPoint, precision int) {
+	fmt.Fprintf(w, `<trkpt lat="%.*f" lon="%.*f">`, precision, point.Latitude, precision, point.Longitude)
 
-	return gpx.String()
+	if point.Elevation != nil {
+		fmt.Fprintf(w, `<ele>%.2f</ele>`, *point.Elevation)
+	}
+
+	if point.Time != nil {
+		fmt.Fprintf(w, `<time>%s</time>`, point.Time.Format("2006-01-02T15:04:05Z"))
+	}
+
+	io.WriteString(w, `</trkpt>`)
+}
+
+// writeGPXEnd closes the track segment, track and document
+func writeGPXEnd(w io.Writer) {
+	io.WriteString(w, `</trkseg>`)
+	io.WriteString(w, `</trk>`)
+	io.WriteString(w, `</gpx>`)
+}
+
+// xmlEscape escapes a string for safe inclusion in XML text or attribute values
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
 }
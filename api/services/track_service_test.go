@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// wigglyTrack returns n points winding along a sine wave, so simplification has to work
+// for every point it drops
+func wigglyTrack(n int) []TrackCoordinate {
+	points := make([]TrackCoordinate, n)
+	for i := range points {
+		elevation := 100 + float64(i%50)
+		points[i] = TrackCoordinate{
+			Latitude:  47 + 0.01*math.Sin(float64(i)/20),
+			Longitude: 8 + float64(i)*0.0001,
+			Elevation: &elevation,
+		}
+	}
+	return points
+}
+
+func TestFitTrackCoordinatesStaysWithinBudget(t *testing.T) {
+	coordinates := map[uint][]TrackCoordinate{1: wigglyTrack(20000), 2: wigglyTrack(5000)}
+	const maxBytes = 50 * 1024
+
+	s := &TrackService{}
+	fitted, payload, err := s.FitTrackCoordinates(coordinates, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) > maxBytes {
+		t.Fatalf("payload is %d bytes, over the %d byte budget", len(payload), maxBytes)
+	}
+	if len(fitted[1]) >= 20000 || len(fitted[1]) < 2 || len(fitted[2]) < 2 {
+		t.Fatalf("fitted to %d and %d points, want simplified tracks", len(fitted[1]), len(fitted[2]))
+	}
+}
+
+func TestFitTrackCoordinatesWithinBudgetUnchanged(t *testing.T) {
+	coordinates := map[uint][]TrackCoordinate{1: wigglyTrack(10)}
+
+	s := &TrackService{}
+	fitted, _, err := s.FitTrackCoordinates(coordinates, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted[1]) != 10 {
+		t.Fatalf("len = %d, want all 10 points", len(fitted[1]))
+	}
+}
+
+func TestFitTrackCoordinatesTooLarge(t *testing.T) {
+	coordinates := map[uint][]TrackCoordinate{1: wigglyTrack(1000)}
+
+	s := &TrackService{}
+	if _, _, err := s.FitTrackCoordinates(coordinates, 10); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("err = %v, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestBoundsClauseAcrossAntimeridian(t *testing.T) {
+	clause, args := boundsClause(60, -25, -170, 170)
+	if clause != "north >= ? AND south <= ? AND (east >= ? OR west <= ?)" {
+		t.Errorf("clause = %q, want the longitude ranges west..180 and -180..east", clause)
+	}
+	if want := []interface{}{-25.0, 60.0, 170.0, -170.0}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
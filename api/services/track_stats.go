@@ -0,0 +1,284 @@
+package services
+
+import (
+	"math"
+	"time"
+
+	"mytracks-api/models"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// StatsOptions tunes how ComputeTrackStats derives stats from points
+type StatsOptions struct {
+	SmoothingWindow int     // Moving-average window for elevation smoothing; 1 or less disables it
+	GainThreshold   float64 // Meters of climb or descent ignored as noise in ElevationGain/Loss
+	Vincenty        bool    // Measure segments on the WGS 84 ellipsoid instead of a sphere
+	Distance3D      bool    // Include elevation changes in Distance and the speeds
+	// Drift filtering: a point closer than MinSegmentDistance meters to the last accepted
+	// point, or reached from it faster than MaxSegmentSpeed m/s, is left out of distances
+	// and speeds. Zero disables either check.
+	MinSegmentDistance float64
+	MaxSegmentSpeed    float64
+}
+
+// maxConsecutiveSpikes is how many points in a row may be rejected as speed spikes before
+// the filter assumes the last accepted point was the bad one and starts over from the next
+const maxConsecutiveSpikes = 5
+
+// segmentDistance returns the distance in meters between two consecutive points
+func (opts StatsOptions) segmentDistance(from, to *models.TrackPoint) float64 {
+	if opts.Vincenty {
+		return vincentyDistance(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	}
+	return haversineDistance(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+}
+
+// compute3DDistance combines the horizontal distance between two points with their
+// elevation difference. Without elevation on both points it is just the horizontal distance.
+func compute3DDistance(horizontal float64, from, to *models.TrackPoint) float64 {
+	if from.Elevation == nil || to.Elevation == nil {
+		return horizontal
+	}
+	return math.Hypot(horizontal, *to.Elevation-*from.Elevation)
+}
+
+// TrackStats is everything derived from a track's points
+type TrackStats struct {
+	Distance              float64 // in meters, Distance2D or Distance3D depending on StatsOptions
+	Distance2D            float64 // in meters, over the ground
+	Distance3D            float64 // in meters, including elevation changes
+	FilteredPointCount    int     // Points left out of distances and speeds as GPS drift
+	ElevationGain         float64 // in meters, after smoothing
+	ElevationLoss         float64 // in meters, after smoothing
+	RawElevationGain      float64 // in meters, before smoothing
+	SmoothedElevationGain float64 // in meters, after smoothing
+	MaxElevation          float64
+	MinElevation          float64
+	StartTime             *time.Time
+	EndTime               *time.Time
+	HasTimestamps         bool
+	Duration              int // in seconds
+	MovingTime            int // in seconds, excluding pauses
+	AverageSpeed          float64
+	MaxSpeed              float64
+	PointCount            int
+	Bounds                models.Bounds
+	BoundingBoxAreaKm2    float64
+	CentroidLat           float64
+	CentroidLon           float64
+	Geohash               string
+	BoundingCircle        *models.BoundingCircle
+}
+
+// ComputeTrackStats derives distance, elevation gain/loss and range, start/end time,
+// duration, speeds, bounds, centroid, geohash and bounding circle from points, in order.
+// Distances and speeds skip points that look like GPS drift (see StatsOptions); bounds,
+// elevations and times still use every point. Elevation gain/loss are computed from
+// smoothed elevations, ignoring changes within GainThreshold; the unsmoothed,
+// unthresholded gain is kept in RawElevationGain for comparison. It doesn't modify points.
+func ComputeTrackStats(points []models.TrackPoint, opts StatsOptions) TrackStats {
+	var minLat, maxLat, minLon, maxLon float64
+	var minEle, maxEle float64
+	var totalDistance, total2D, total3D float64
+	var maxSpeed, movingSeconds float64
+	var startTime, endTime *time.Time
+	var filtered, spikes int
+
+	// prevPoint is the last point accepted by the drift filter. Segments are measured and
+	// timed from it, so skipped points neither shorten nor lengthen a segment; a pause shows
+	// up as a slow segment and stays out of moving time. lastNear is when a point was last
+	// seen within MinSegmentDistance of prevPoint, which the spike check times jumps from.
+	var prevPoint *models.TrackPoint
+	var lastNear *time.Time
+	var elevations []float64
+	hasElevation := false
+
+	for i := range points {
+		trackPoint := &points[i]
+
+		// Update bounds
+		if i == 0 {
+			minLat, maxLat = trackPoint.Latitude, trackPoint.Latitude
+			minLon, maxLon = trackPoint.Longitude, trackPoint.Longitude
+		} else {
+			minLat = math.Min(minLat, trackPoint.Latitude)
+			maxLat = math.Max(maxLat, trackPoint.Latitude)
+			minLon = math.Min(minLon, trackPoint.Longitude)
+			maxLon = math.Max(maxLon, trackPoint.Longitude)
+		}
+
+		// Update elevation range
+		if trackPoint.Elevation != nil {
+			elevations = append(elevations, *trackPoint.Elevation)
+			if !hasElevation {
+				minEle, maxEle = *trackPoint.Elevation, *trackPoint.Elevation
+				hasElevation = true
+			} else {
+				minEle = math.Min(minEle, *trackPoint.Elevation)
+				maxEle = math.Max(maxEle, *trackPoint.Elevation)
+			}
+		}
+
+		// Update time range
+		if trackPoint.Time != nil {
+			if startTime == nil || trackPoint.Time.Before(*startTime) {
+				startTime = trackPoint.Time
+			}
+			if endTime == nil || trackPoint.Time.After(*endTime) {
+				endTime = trackPoint.Time
+			}
+		}
+
+		// Calculate distance from the last accepted point, skipping GPS drift
+		if prevPoint != nil {
+			horizontal := opts.segmentDistance(prevPoint, trackPoint)
+			seconds, jumpSeconds := 0.0, 0.0
+			if prevPoint.Time != nil && trackPoint.Time != nil {
+				seconds = trackPoint.Time.Sub(*prevPoint.Time).Seconds()
+			}
+			if lastNear != nil && trackPoint.Time != nil {
+				jumpSeconds = trackPoint.Time.Sub(*lastNear).Seconds()
+			}
+
+			// Jitter while standing still, or slow movement that hasn't cleared the threshold yet
+			if opts.MinSegmentDistance > 0 && horizontal < opts.MinSegmentDistance {
+				filtered++
+				if trackPoint.Time != nil {
+					lastNear = trackPoint.Time
+				}
+				continue
+			}
+
+			// A jump no one could have made, even leaving from where we were last seen; after
+			// several in a row, restart from here
+			if opts.MaxSegmentSpeed > 0 && jumpSeconds > 0 && horizontal/jumpSeconds > opts.MaxSegmentSpeed {
+				filtered++
+				spikes++
+				if spikes > maxConsecutiveSpikes {
+					spikes = 0
+					prevPoint, lastNear = trackPoint, trackPoint.Time
+				}
+				continue
+			}
+			spikes = 0
+
+			distance3D := compute3DDistance(horizontal, prevPoint, trackPoint)
+			total2D += horizontal
+			total3D += distance3D
+
+			distance := horizontal
+			if opts.Distance3D {
+				distance = distance3D
+			}
+			totalDistance += distance
+
+			// Track the fastest segment between consecutive timestamped points
+			if seconds > 0 {
+				speed := distance / seconds
+				maxSpeed = math.Max(maxSpeed, speed)
+
+				// Only intervals where we were actually moving count toward moving time
+				if speed > movingSpeedThreshold {
+					movingSeconds += seconds
+				}
+			}
+		}
+
+		prevPoint, lastNear = trackPoint, trackPoint.Time
+	}
+
+	// Calculate elevation gain/loss, smoothing out GPS/barometer noise first
+	rawGain, _ := elevationGainLoss(elevations, 0)
+	smoothedGain, smoothedLoss := elevationGainLoss(smoothElevations(elevations, opts.SmoothingWindow), opts.GainThreshold)
+
+	// Set calculated values
+	stats := TrackStats{
+		Distance:              totalDistance,
+		Distance2D:            total2D,
+		Distance3D:            total3D,
+		FilteredPointCount:    filtered,
+		ElevationGain:         smoothedGain,
+		ElevationLoss:         smoothedLoss,
+		RawElevationGain:      rawGain,
+		SmoothedElevationGain: smoothedGain,
+		MaxElevation:          maxEle,
+		MinElevation:          minEle,
+		StartTime:             startTime,
+		EndTime:               endTime,
+		HasTimestamps:         startTime != nil,
+		PointCount:            len(points),
+		MaxSpeed:              maxSpeed,
+		MovingTime:            int(movingSeconds),
+	}
+
+	// Calculate duration
+	if startTime != nil && endTime != nil {
+		stats.Duration = int(endTime.Sub(*startTime).Seconds())
+	}
+
+	// Speeds stay zero for tracks without timestamps
+	if stats.Duration > 0 {
+		stats.AverageSpeed = totalDistance / float64(stats.Duration)
+	}
+
+	// Set bounds
+	stats.Bounds = models.Bounds{
+		North: maxLat,
+		South: minLat,
+		East:  maxLon,
+		West:  minLon,
+	}
+
+	stats.BoundingBoxAreaKm2 = boundingBoxArea(stats.Bounds)
+
+	// Calculate centroid and geohash for spatial indexing
+	centroidLat, centroidLon := trackCentroid(points)
+	stats.CentroidLat = centroidLat
+	stats.CentroidLon = centroidLon
+	stats.Geohash = geohash.Encode(centroidLat, centroidLon)
+
+	// Bounding circle centered on the centroid, covering the farthest point
+	var radius float64
+	for _, point := range points {
+		distance := haversineDistance(centroidLat, centroidLon, point.Latitude, point.Longitude)
+		if distance > radius {
+			radius = distance
+		}
+	}
+	stats.BoundingCircle = &models.BoundingCircle{
+		Lat:          centroidLat,
+		Lon:          centroidLon,
+		RadiusMeters: radius,
+	}
+
+	return stats
+}
+
+// applyTo copies the stats onto track
+func (stats TrackStats) applyTo(track *models.GPXTrack) {
+	track.Distance = stats.Distance
+	track.Distance2D = stats.Distance2D
+	track.Distance3D = stats.Distance3D
+	track.FilteredPointCount = stats.FilteredPointCount
+	track.ElevationGain = stats.ElevationGain
+	track.ElevationLoss = stats.ElevationLoss
+	track.RawElevationGain = stats.RawElevationGain
+	track.SmoothedElevationGain = stats.SmoothedElevationGain
+	track.MaxElevation = stats.MaxElevation
+	track.MinElevation = stats.MinElevation
+	track.StartTime = stats.StartTime
+	track.EndTime = stats.EndTime
+	track.HasTimestamps = stats.HasTimestamps
+	track.Duration = stats.Duration
+	track.MovingTime = stats.MovingTime
+	track.AverageSpeed = stats.AverageSpeed
+	track.MaxSpeed = stats.MaxSpeed
+	track.PointCount = stats.PointCount
+	track.Bounds = stats.Bounds
+	track.BoundingBoxAreaKm2 = stats.BoundingBoxAreaKm2
+	track.CentroidLat = stats.CentroidLat
+	track.CentroidLon = stats.CentroidLon
+	track.Geohash = stats.Geohash
+	track.BoundingCircle = stats.BoundingCircle
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"mytracks-api/models"
+)
+
+// timedPoints builds points one second apart from parallel slices of coordinates and
+// elevations
+func timedPoints(lats, lons, elevations []float64) []models.TrackPoint {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	points := make([]models.TrackPoint, len(lats))
+	for i := range points {
+		timestamp := start.Add(time.Duration(i) * time.Second)
+		points[i] = models.TrackPoint{Latitude: lats[i], Longitude: lons[i], Time: &timestamp}
+		if elevations != nil {
+			elevation := elevations[i]
+			points[i].Elevation = &elevation
+		}
+	}
+	return points
+}
+
+func TestFlatJitteryElevationHasNoSmoothedGain(t *testing.T) {
+	// A walk along flat ground with up to 2 m of barometer noise
+	noise := []float64{0, 1.5, -1.2, 2, -0.8, 0.6, -1.9, 1.1, -0.3, 1.7}
+	n := 200
+	lats, lons, elevations := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i] = 47
+		lons[i] = 8 + float64(i)*0.00002
+		elevations[i] = 500 + noise[i%len(noise)]
+	}
+
+	stats := ComputeTrackStats(timedPoints(lats, lons, elevations), NewGPXService().statsOptions)
+	if stats.RawElevationGain < 100 {
+		t.Fatalf("raw gain = %.1f m, want the noise to add up", stats.RawElevationGain)
+	}
+	if stats.SmoothedElevationGain > 1 || stats.ElevationLoss > 1 {
+		t.Fatalf("smoothed gain/loss = %.2f/%.2f m, want near zero on flat ground",
+			stats.SmoothedElevationGain, stats.ElevationLoss)
+	}
+}
+
+func TestStationaryJitterHasNearZeroDistance(t *testing.T) {
+	// Standing still for ten minutes with sub-meter jitter, and one 500 m spike
+	offsets := []float64{0, 0.000003, -0.000002, 0.000004, -0.000004, 0.000001, -0.000003, 0.000002}
+	n := 600
+	lats, lons := make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i] = 47 + offsets[i%len(offsets)]
+		lons[i] = 8 + offsets[(i+3)%len(offsets)]
+	}
+	lats[300] += 0.0045
+
+	stats := ComputeTrackStats(timedPoints(lats, lons, nil), NewGPXService().statsOptions)
+	if stats.Distance > 2 {
+		t.Fatalf("distance = %.1f m, want near zero for a stationary track", stats.Distance)
+	}
+	if stats.FilteredPointCount < n-2 {
+		t.Fatalf("filtered %d points, want nearly all %d", stats.FilteredPointCount, n)
+	}
+	if stats.MaxSpeed > 1 {
+		t.Fatalf("max speed = %.1f m/s, want the spike ignored", stats.MaxSpeed)
+	}
+
+	// Without the filter the same points wander for hundreds of meters
+	unfiltered := ComputeTrackStats(timedPoints(lats, lons, nil), StatsOptions{})
+	if unfiltered.Distance < 100 {
+		t.Fatalf("unfiltered distance = %.1f m, want the jitter to add up", unfiltered.Distance)
+	}
+}
+
+func TestBoundingBoxAreaShrinksTowardThePoles(t *testing.T) {
+	// The same one-degree square at the equator and at 60°N
+	equator := boundingBoxArea(models.Bounds{North: 0.5, South: -0.5, East: 1, West: 0})
+	north := boundingBoxArea(models.Bounds{North: 60.5, South: 59.5, East: 1, West: 0})
+
+	// About 111 km × 111 km at the equator, and half as wide at 60°N where cos(lat) = 0.5
+	if equator < 12300 || equator > 12400 {
+		t.Errorf("equator area = %.0f km², want about 12364", equator)
+	}
+	if ratio := north / equator; ratio < 0.49 || ratio > 0.51 {
+		t.Errorf("60°N area = %.0f km², %.3f of the equator's, want about half", north, ratio)
+	}
+}
+
+func TestComputeTrackStats(t *testing.T) {
+	// Four points 0.001° of latitude (about 111 m) apart, a minute each
+	lats := []float64{47, 47.001, 47.002, 47.003}
+	lons := []float64{8, 8, 8, 8}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	walk := func(elevations []float64) []models.TrackPoint {
+		points := timedPoints(lats, lons, elevations)
+		for i := range points {
+			timestamp := start.Add(time.Duration(i) * time.Minute)
+			points[i].Time = &timestamp
+		}
+		return points
+	}
+	untimed := walk(nil)
+	for i := range untimed {
+		untimed[i].Time = nil
+	}
+	partial := walk([]float64{100, 0, 0, 130})
+	partial[1].Elevation, partial[2].Elevation = nil, nil
+
+	tests := []struct {
+		name   string
+		points []models.TrackPoint
+		check  func(t *testing.T, stats TrackStats)
+	}{
+		{"empty", nil, func(t *testing.T, stats TrackStats) {
+			if stats.PointCount != 0 || stats.Distance != 0 || stats.Duration != 0 || stats.HasTimestamps {
+				t.Errorf("got %+v, want zero stats", stats)
+			}
+		}},
+		{"single point", walk([]float64{100, 0, 0, 0})[:1], func(t *testing.T, stats TrackStats) {
+			if stats.PointCount != 1 || stats.Distance != 0 || stats.Duration != 0 {
+				t.Errorf("count/distance/duration = %d/%v/%d, want 1/0/0", stats.PointCount, stats.Distance, stats.Duration)
+			}
+			if stats.Bounds != (models.Bounds{North: 47, South: 47, East: 8, West: 8}) {
+				t.Errorf("bounds = %+v, want the point", stats.Bounds)
+			}
+			if stats.MinElevation != 100 || stats.MaxElevation != 100 {
+				t.Errorf("elevation range = %v..%v, want 100", stats.MinElevation, stats.MaxElevation)
+			}
+			if stats.BoundingCircle == nil || stats.BoundingCircle.RadiusMeters > 1e-6 {
+				t.Errorf("bounding circle = %+v, want zero radius", stats.BoundingCircle)
+			}
+		}},
+		{"missing elevation", walk(nil), func(t *testing.T, stats TrackStats) {
+			if stats.ElevationGain != 0 || stats.ElevationLoss != 0 || stats.MaxElevation != 0 || stats.MinElevation != 0 {
+				t.Errorf("elevation stats = %+v, want zeros", stats)
+			}
+			if stats.Distance3D != stats.Distance2D {
+				t.Errorf("3D distance = %v, want the 2D distance %v without elevations", stats.Distance3D, stats.Distance2D)
+			}
+		}},
+		{"partial elevation", partial, func(t *testing.T, stats TrackStats) {
+			if stats.MinElevation != 100 || stats.MaxElevation != 130 || stats.RawElevationGain != 30 {
+				t.Errorf("elevation range %v..%v, raw gain %v, want the points with elevations only",
+					stats.MinElevation, stats.MaxElevation, stats.RawElevationGain)
+			}
+		}},
+		{"walk", walk([]float64{100, 110, 120, 130}), func(t *testing.T, stats TrackStats) {
+			if stats.Distance < 333 || stats.Distance > 334 {
+				t.Errorf("distance = %.1f m, want about 333.6", stats.Distance)
+			}
+			if stats.Duration != 180 || stats.MovingTime != 180 || !stats.HasTimestamps {
+				t.Errorf("duration/moving = %d/%d s, want 180/180", stats.Duration, stats.MovingTime)
+			}
+			if stats.AverageSpeed < 1.85 || stats.AverageSpeed > 1.86 {
+				t.Errorf("average speed = %.3f m/s, want about 1.853", stats.AverageSpeed)
+			}
+			if stats.RawElevationGain != 30 || stats.MinElevation != 100 || stats.MaxElevation != 130 {
+				t.Errorf("raw gain %v, range %v..%v", stats.RawElevationGain, stats.MinElevation, stats.MaxElevation)
+			}
+			if stats.Bounds != (models.Bounds{North: 47.003, South: 47, East: 8, West: 8}) {
+				t.Errorf("bounds = %+v", stats.Bounds)
+			}
+		}},
+		{"no timestamps", untimed, func(t *testing.T, stats TrackStats) {
+			if stats.HasTimestamps || stats.Duration != 0 || stats.AverageSpeed != 0 || stats.MaxSpeed != 0 {
+				t.Errorf("got timestamps/duration/speeds %v/%d/%v/%v, want none", stats.HasTimestamps, stats.Duration, stats.AverageSpeed, stats.MaxSpeed)
+			}
+			if stats.Distance == 0 {
+				t.Error("distance doesn't need timestamps")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, ComputeTrackStats(tt.points, NewGPXService().statsOptions))
+		})
+	}
+}
+
+func TestCompute3DDistance(t *testing.T) {
+	elevation := func(meters float64) *float64 { return &meters }
+
+	tests := []struct {
+		name       string
+		horizontal float64
+		from, to   *float64
+		want       float64
+	}{
+		{"pure vertical climb", 0, elevation(1000), elevation(1250), 250},
+		{"pure vertical descent", 0, elevation(1250), elevation(1000), 250},
+		{"steep climb", 30, elevation(100), elevation(140), 50},
+		{"flat", 80, elevation(100), elevation(100), 80},
+		{"missing elevation", 80, nil, elevation(140), 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compute3DDistance(tt.horizontal, &models.TrackPoint{Elevation: tt.from}, &models.TrackPoint{Elevation: tt.to})
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("distance = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerticalClimbDistance3D(t *testing.T) {
+	// Straight up a shaft: the position doesn't change while elevation rises 10 m a point
+	n := 11
+	lats, lons, elevations := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i], lons[i], elevations[i] = 47, 8, 1000+float64(i)*10
+	}
+
+	// The drift filter measures horizontal movement and would drop every point, so it's off
+	stats := ComputeTrackStats(timedPoints(lats, lons, elevations), StatsOptions{Distance3D: true})
+	if stats.Distance2D != 0 {
+		t.Errorf("2D distance = %v, want 0", stats.Distance2D)
+	}
+	if math.Abs(stats.Distance3D-100) > 1e-9 || stats.Distance != stats.Distance3D {
+		t.Errorf("3D distance = %v, distance = %v, want 100", stats.Distance3D, stats.Distance)
+	}
+}
+
+func TestElevationGainLossThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		elevations []float64
+		threshold  float64
+		gain, loss float64
+	}{
+		{"noise below threshold", []float64{100, 100.01, 100, 100.02, 99.99, 100.01, 100}, 3, 0, 0},
+		{"noise summed without threshold", []float64{100, 101, 100, 101, 100}, 0, 2, 2},
+		{"noise of a meter ignored", []float64{100, 101, 100, 101, 100}, 3, 0, 0},
+		{"slow climb counted once past the threshold", []float64{100, 101, 102, 103, 104, 108}, 3, 8, 0},
+		{"climb then descent", []float64{100, 110, 120, 115, 105}, 3, 20, 15},
+		{"empty", nil, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gain, loss := elevationGainLoss(tt.elevations, tt.threshold)
+			if math.Abs(gain-tt.gain) > 1e-9 || math.Abs(loss-tt.loss) > 1e-9 {
+				t.Fatalf("gain/loss = %v/%v, want %v/%v", gain, loss, tt.gain, tt.loss)
+			}
+		})
+	}
+}
+
+func TestNoiseDoesNotInflateGain(t *testing.T) {
+	// A flat walk with a centimeter of noise on every point
+	n := 1000
+	lats, lons, elevations := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i], lons[i] = 47, 8+float64(i)*0.00002
+		elevations[i] = 250 + 0.01*float64(i%2)
+	}
+
+	opts := StatsOptions{SmoothingWindow: 1, GainThreshold: defaultElevationGainThreshold}
+	stats := ComputeTrackStats(timedPoints(lats, lons, elevations), opts)
+	if stats.ElevationGain != 0 {
+		t.Errorf("gain = %v m, want 0 with a %v m threshold", stats.ElevationGain, opts.GainThreshold)
+	}
+	if stats.RawElevationGain < 4.9 {
+		t.Errorf("raw gain = %v m, want the noise summed", stats.RawElevationGain)
+	}
+}
+
+func TestSlowDenseMovementSpeeds(t *testing.T) {
+	// Walking east along the equator at 1 m/s, sampled once a second, for five minutes
+	const metersPerDegree = 6371000 * math.Pi / 180
+	n := 301
+	lats, lons := make([]float64, n), make([]float64, n)
+	for i := range lats {
+		lats[i], lons[i] = 0.001, float64(i)/metersPerDegree
+	}
+
+	opts := StatsOptions{MinSegmentDistance: 5, MaxSegmentSpeed: defaultMaxSegmentSpeed}
+	stats := ComputeTrackStats(timedPoints(lats, lons, nil), opts)
+	if stats.MaxSpeed < 0.95 || stats.MaxSpeed > 1.05 {
+		t.Errorf("max speed = %.2f m/s, want about 1", stats.MaxSpeed)
+	}
+	if stats.MovingTime < 290 {
+		t.Errorf("moving time = %d s, want about 300", stats.MovingTime)
+	}
+	if stats.Distance < 290 || stats.Distance > 300 {
+		t.Errorf("distance = %.1f m, want about 300", stats.Distance)
+	}
+}
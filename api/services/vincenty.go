@@ -0,0 +1,72 @@
+package services
+
+import "math"
+
+// WGS 84 ellipsoid
+const (
+	wgs84SemiMajorAxis = 6378137.0
+	wgs84Flattening    = 1 / 298.257223563
+	wgs84SemiMinorAxis = wgs84SemiMajorAxis * (1 - wgs84Flattening)
+)
+
+// vincentyMaxIterations bounds the lambda iteration, which fails to converge for nearly
+// antipodal points
+const vincentyMaxIterations = 200
+
+// vincentyDistance returns the distance in meters between two points on the WGS 84
+// ellipsoid using Vincenty's inverse formula, accurate to well under a millimeter where
+// haversine is off by up to 0.5%. Nearly antipodal pairs, where the iteration doesn't
+// converge, fall back to haversineDistance.
+func vincentyDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := math.Pi / 180
+	f := wgs84Flattening
+
+	l := (lon2 - lon1) * toRadians
+	u1 := math.Atan((1 - f) * math.Tan(lat1*toRadians))
+	u2 := math.Atan((1 - f) * math.Tan(lat2*toRadians))
+	sinU1, cosU1 := math.Sin(u1), math.Cos(u1)
+	sinU2, cosU2 := math.Sin(u2), math.Cos(u2)
+
+	lambda := l
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < vincentyMaxIterations; i++ {
+		sinLambda, cosLambda := math.Sin(lambda), math.Cos(lambda)
+		sinSigma = math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) +
+			(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			return 0 // coincident points
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+
+		// Both points on the equator
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+
+		c := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+		previous := lambda
+		lambda = l + (1-c)*f*sinAlpha*
+			(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-previous) < 1e-12 {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return haversineDistance(lat1, lon1, lat2, lon2)
+	}
+
+	a, b := wgs84SemiMajorAxis, wgs84SemiMinorAxis
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	bigA := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	bigB := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := bigB * sinSigma * (cos2SigmaM + bigB/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		bigB/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return b * bigA * (sigma - deltaSigma)
+}
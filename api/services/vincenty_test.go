@@ -0,0 +1,47 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+// dms converts degrees, minutes and seconds to decimal degrees
+func dms(degrees, minutes, seconds float64) float64 {
+	return math.Copysign(math.Abs(degrees)+minutes/60+seconds/3600, degrees)
+}
+
+func TestVincentyDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want, tolerance        float64
+	}{
+		// Flinders Peak to Buninyong, the worked example from Geoscience Australia
+		{"Flinders Peak to Buninyong",
+			dms(-37, 57, 3.72030), dms(144, 25, 29.52440), dms(-37, 39, 10.15610), dms(143, 55, 35.38390),
+			54972.271, 0.001},
+		{"coincident points", 47.1, 8.2, 47.1, 8.2, 0, 0},
+		// A quarter of the equator is a quarter of its circumference
+		{"along the equator", 0, 0, 0, 90, math.Pi * wgs84SemiMajorAxis / 2, 0.001},
+		// Pole to pole along a meridian is twice the meridian quadrant
+		{"pole to pole", 90, 0, -90, 0, 2 * 10001965.729, 0.001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := vincentyDistance(tt.lat1, tt.lon1, tt.lat2, tt.lon2)
+			if math.Abs(got-tt.want) > tt.tolerance {
+				t.Fatalf("distance = %.4f m, want %.4f m", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVincentyDistanceAntipodalFallback(t *testing.T) {
+	// Nearly antipodal points, where the lambda iteration doesn't converge
+	lat1, lon1, lat2, lon2 := 0.0, 0.0, 0.5, 179.7
+
+	got := vincentyDistance(lat1, lon1, lat2, lon2)
+	if want := haversineDistance(lat1, lon1, lat2, lon2); got != want {
+		t.Fatalf("distance = %.3f m, want the haversine fallback %.3f m", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mytracks-api/config"
+	"mytracks-api/models"
+	"mytracks-api/services"
+
+	"gorm.io/gorm"
+)
+
+// seedSources lists what GPX_PATH names: tar.gz archives, plus loose .gpx and .gpx.gz
+// files found in directories
+type seedSources struct {
+	archives []string
+	files    []string
+}
+
+// resolveSeedSources expands GPX_PATH, a comma-separated list of archives and directories.
+// Directories contribute their .tar.gz/.tgz archives and GPX files, in name order.
+func resolveSeedSources(gpxPath string) (seedSources, error) {
+	var sources seedSources
+	for _, path := range strings.Split(gpxPath, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return seedSources{}, fmt.Errorf("GPX source %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			sources.archives = append(sources.archives, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return seedSources{}, fmt.Errorf("GPX source %s: %w", path, err)
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			name := strings.ToLower(entry.Name())
+			switch {
+			case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+				sources.archives = append(sources.archives, filepath.Join(path, entry.Name()))
+			case isGPXName(name):
+				sources.files = append(sources.files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return sources, nil
+}
+
+// isSingleArchive reports whether GPX_PATH names one archive file, the only layout the
+// S3 download and refresh apply to
+func isSingleArchive(gpxPath string) bool {
+	if strings.Contains(gpxPath, ",") {
+		return false
+	}
+	info, err := os.Stat(gpxPath)
+	return err != nil || !info.IsDir()
+}
+
+// loadGPXFiles loads loose GPX files one at a time, inserting them in batches like the
+// archive loader. loaded is the count handled so far across all sources; the new count is
+// returned. A positive maxTracks stops loading once that many tracks have been handled.
+func loadGPXFiles(ctx context.Context, db *gorm.DB, files []string, gpxService *services.GPXService, trackService *services.TrackService, maxTracks, loaded int) (int, error) {
+	dedupe := config.Bool("SEED_DEDUPLICATE", false)
+	total := getSeedingProgress().TotalTracks
+
+	batch := make([]*models.GPXTrack, 0, seedBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		loaded += insertTrackBatch(ctx, db, trackService, batch, dedupe)
+		batch = batch[:0]
+		updateSeedingProgress(loaded, total, false, "")
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return loaded, ctx.Err()
+		}
+		if maxTracks > 0 && loaded+len(batch) >= maxTracks {
+			break
+		}
+
+		track, err := parseGPXFile(gpxService, file)
+		if err != nil {
+			log.Printf("Error parsing GPX file %s: %v", file, err)
+			recordSeedingFailure(filepath.Base(file))
+			continue
+		}
+		batch = append(batch, track)
+		if len(batch) >= seedBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	return loaded, nil
+}
+
+// isGPXName reports whether a file or archive entry name is a GPX file, either plain or
+// individually gzipped
+func isGPXName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".gpx") || strings.HasSuffix(lower, ".gpx.gz")
+}
+
+// parseGPXEntry parses the GPX file called name from r, decompressing .gpx.gz files on
+// the fly. The track is stored under the base name without the .gz suffix.
+func parseGPXEntry(gpxService *services.GPXService, name string, r io.Reader) (*models.GPXTrack, error) {
+	filename := filepath.Base(name)
+	if strings.HasSuffix(strings.ToLower(filename), ".gz") {
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzipped GPX: %w", err)
+		}
+		defer gzReader.Close()
+		r = gzReader
+		filename = filename[:len(filename)-len(".gz")]
+	}
+	return gpxService.ParseGPXReader(r, filename)
+}
+
+// parseGPXFile parses a loose GPX or .gpx.gz file
+func parseGPXFile(gpxService *services.GPXService, path string) (*models.GPXTrack, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	return parseGPXEntry(gpxService, path, file)
+}
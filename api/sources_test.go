@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"mytracks-api/services"
+)
+
+const sampleGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="47.6005" lon="-122.3295"><name>Viewpoint</name></wpt>
+  <trk><name>Archive loop</name><trkseg>
+    <trkpt lat="47.6000" lon="-122.3300"><ele>10</ele><time>2024-05-01T08:00:00Z</time></trkpt>
+    <trkpt lat="47.6010" lon="-122.3290"><ele>14</ele><time>2024-05-01T08:01:00Z</time></trkpt>
+    <trkpt lat="47.6020" lon="-122.3280"><ele>19</ele><time>2024-05-01T08:02:00Z</time></trkpt>
+    <trkpt lat="47.6030" lon="-122.3270"><ele>15</ele><time>2024-05-01T08:03:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+// tarEntry writes data as the only entry of a tar archive and returns a reader positioned
+// at that entry
+func tarEntry(t *testing.T, name string, data []byte) *tar.Reader {
+	t.Helper()
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&archive)
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestParseGPXEntryMatchesParseGPXData(t *testing.T) {
+	gpxService := services.NewGPXService()
+	want, err := gpxService.ParseGPXData([]byte(sampleGPX), "loop.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streamed from the archive a byte at a time, the worst case for gpxgo's version sniffing
+	tr := tarEntry(t, "tracks/loop.gpx", []byte(sampleGPX))
+	got, err := parseGPXEntry(gpxService, "tracks/loop.gpx", iotest.OneByteReader(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed parse differs:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseGPXEntryGzipped(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(sampleGPX))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gpxService := services.NewGPXService()
+	want, err := gpxService.ParseGPXData([]byte(sampleGPX), "loop.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tarEntry(t, "tracks/loop.GPX.gz", compressed.Bytes())
+	got, err := parseGPXEntry(gpxService, "tracks/loop.GPX.gz", tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Filename != "loop.GPX" {
+		t.Errorf("filename = %q, want the name without .gz", got.Filename)
+	}
+	got.Filename = want.Filename
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("gzipped parse differs:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseGPXEntryCorruptGzip(t *testing.T) {
+	tr := tarEntry(t, "broken.gpx.gz", []byte(sampleGPX))
+	if _, err := parseGPXEntry(services.NewGPXService(), "broken.gpx.gz", tr); err == nil {
+		t.Fatal("want an error for an entry that isn't gzip data")
+	}
+}
+
+func TestIsGPXName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"loop.gpx":        true,
+		"tracks/LOOP.GPX": true,
+		"loop.gpx.gz":     true,
+		"loop.tar.gz":     false,
+		"loop.gz":         false,
+		"notes.txt":       false,
+	} {
+		if got := isGPXName(name); got != want {
+			t.Errorf("isGPXName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// States of a response under timeoutMiddleware
+const (
+	responsePending int32 = iota
+	responseStarted
+	responseTimedOut
+)
+
+// startedWriter records when the body starts, after which the deadline no longer applies
+type startedWriter struct {
+	gin.ResponseWriter
+	state *atomic.Int32
+}
+
+func (w *startedWriter) Write(data []byte) (int, error) {
+	w.state.CompareAndSwap(responsePending, responseStarted)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *startedWriter) WriteString(s string) (int, error) {
+	w.state.CompareAndSwap(responsePending, responseStarted)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// deadlineContext reports a timeout as context.DeadlineExceeded, like a context with a
+// deadline would, although it is canceled through context.WithCancelCause
+type deadlineContext struct {
+	context.Context
+}
+
+func (c deadlineContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// timeoutMiddleware gives each request's context a deadline until the response body starts.
+// Services pass that context to GORM, so the query is canceled when it runs out; handlers
+// that haven't written anything by then get a 503. Once bytes are sent the deadline is
+// lifted, so streamed exports (GPX, zip, CSV, NDJSON) run to the end instead of being cut
+// off after their 200; a client disconnecting still cancels them.
+func timeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+		c.Request = c.Request.WithContext(deadlineContext{ctx})
+
+		state := new(atomic.Int32)
+		c.Writer = &startedWriter{ResponseWriter: c.Writer, state: state}
+		timer := time.AfterFunc(timeout, func() {
+			if state.CompareAndSwap(responsePending, responseTimedOut) {
+				cancel(context.DeadlineExceeded)
+			}
+		})
+		defer timer.Stop()
+
+		c.Next()
+
+		if state.Load() == responseTimedOut && !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Request timed out"})
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveWithTimeout(timeout time.Duration, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(timeoutMiddleware(timeout))
+	r.GET("/", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestTimeoutBeforeFirstByte(t *testing.T) {
+	var ctxErr error
+	w := serveWithTimeout(20*time.Millisecond, func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		ctxErr = c.Request.Context().Err()
+	})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if !errors.Is(ctxErr, context.DeadlineExceeded) {
+		t.Fatalf("context error = %v, want DeadlineExceeded", ctxErr)
+	}
+}
+
+func TestTimeoutLiftedOnceStreaming(t *testing.T) {
+	w := serveWithTimeout(20*time.Millisecond, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("first,")
+		time.Sleep(60 * time.Millisecond)
+		if err := c.Request.Context().Err(); err != nil {
+			t.Errorf("context canceled mid-stream: %v", err)
+			return
+		}
+		c.Writer.WriteString("second")
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "first,second" {
+		t.Fatalf("body = %q, want the whole stream", got)
+	}
+}